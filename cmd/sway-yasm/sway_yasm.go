@@ -9,7 +9,6 @@ import (
 )
 
 func main() {
-	// TODO --status (PID, config, windows count)
 	// TODO slog
 	logger := log.New(os.Stdout, "", 0)
 	if os.Getenv("YASM_LOG") == "" {
@@ -2,21 +2,44 @@ package cmds
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/lithammer/dedent"
+	"github.com/pancsta/sway-yasm/internal/config"
 	"github.com/pancsta/sway-yasm/internal/daemon"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
 	"runtime/debug"
 )
 
-var clipboardSanitize = regexp.MustCompile(`\s+`)
+// exit codes of failed RPC calls
+const (
+	exitErr      = 1
+	exitNoSway   = 2
+	exitNoDaemon = 3
+	exitNoReply  = 4
+	exitProtocol = 5
+)
+
+var (
+	clipboardSanitize = regexp.MustCompile(`\s+`)
+	ansiEscape        = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+)
 
 // ///// ///// /////
 // ///// COBRAS
@@ -27,6 +50,15 @@ func mouseFollowsFocusFlag(cmd *cobra.Command) {
 		"Calls 'input ... map_to_output OUTPUT' on each focus")
 }
 
+func switcherScopeFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("current-output-only", false,
+		"List only windows from the focused output")
+	cmd.Flags().Bool("current-space-only", false,
+		"List only windows from the focused workspace")
+	cmd.Flags().Bool("group-by-output", false,
+		"Group windows by output, with the focused output first")
+}
+
 func GetRootCmd(logger *log.Logger) *cobra.Command {
 
 	cmdDaemon := &cobra.Command{
@@ -35,17 +67,46 @@ func GetRootCmd(logger *log.Logger) *cobra.Command {
 		Run:   cmdDaemon(logger),
 	}
 
+	defaults := config.Default()
+	cmdDaemon.Flags().String("config", config.Path(),
+		"Path to the YAML config file")
 	mouseFollowsFocusFlag(cmdDaemon)
-	cmdDaemon.Flags().Bool("autoconfig", true,
+	cmdDaemon.Flags().Bool("autoconfig", defaults.Daemon.Autoconfig,
 		"Automatically configure the layout and start clipman")
-	cmdDaemon.Flags().Bool("default-keybindings", false,
-		"Add default keybindings")
+	cmdDaemon.Flags().Bool("default-keybindings",
+		defaults.Daemon.DefaultKeybindings, "Add default keybindings")
+	cmdDaemon.Flags().String("row-format", defaults.Switcher.RowFormat,
+		"Go template of a window row, with fields of WindowData, OutputAlias "+
+			"and Marks, and functions pad, trunc and width")
+
+	cmdDaemon.Flags().String("record", "",
+		"Record the sway events and commands to a file, see replay")
+	cmdDaemon.Flags().Bool("dry-run", false,
+		"Only log sway commands and simulate them on the tracked state")
+
+	cmdReplay := &cobra.Command{
+		Use:   "replay FILE",
+		Short: "Replay a recorded daemon session and print the state",
+		Long: "Replay a session recorded with 'daemon --record FILE' through " +
+			"the event handling, without sway, and print the resulting MRU " +
+			"list (win_focus) and windows (win_data) as JSON.",
+		Args: cobra.ExactArgs(1),
+		Run:  cmdReplay(logger),
+	}
 
 	cmdMRUList := &cobra.Command{
 		Use:   "mru-list",
 		Short: "Print a list of MRU window IDs",
 		Run: func(cmd *cobra.Command, args []string) {
-			fmt.Print(daemon.RemoteCall("Daemon.RemoteWinList", daemon.RPCArgs{}))
+			var reply daemon.WindowsReply
+			err := daemon.RemoteCall("Daemon.RemoteWindows",
+				daemon.WindowsArgs{}, &reply)
+			if err != nil {
+				fatalRPC(err)
+			}
+			for _, win := range reply.Windows {
+				fmt.Printf("%d ", win.ID)
+			}
 		},
 	}
 
@@ -54,6 +115,13 @@ func GetRootCmd(logger *log.Logger) *cobra.Command {
 		Short: "Run fzf with a list of windows",
 		Run:   CmdFzfSwitcher,
 	}
+	switcherScopeFlags(cmdFzfSwitcher)
+
+	cmdFzfSpaceSwitcher := &cobra.Command{
+		Use:   "space-switcher",
+		Short: "Run fzf with a list of workspaces",
+		Run:   CmdFzfSpaceSwitcher,
+	}
 
 	cmdFzfPickWin := &cobra.Command{
 		Use:   "pick-win",
@@ -85,10 +153,33 @@ func GetRootCmd(logger *log.Logger) *cobra.Command {
 		Use:   "fzf",
 		Short: "Pure FZF versions of the switcher and pickers",
 		Long: "Pure FZF versions of the switcher and pickers, which allows them " +
-				"to be rendered directly in the terminal.",
+				"to be rendered directly in the terminal. Without fzf installed, " +
+				"the builtin picker is used.",
 	}
 
-	cmdFzf.AddCommand(cmdFzfSwitcher, cmdFzfPickWin, cmdFzfPickSpace, cmdFzfPath, cmdFzfPickClip)
+	cmdFzfServe := &cobra.Command{
+		Use:    "serve",
+		Short:  "Run the warm picker, shown by the daemon",
+		Run:    CmdFzfServe,
+		Hidden: true,
+	}
+
+	cmdFzfPreview := &cobra.Command{
+		Use:    "preview DIR ROW",
+		Short:  "Print the preview of a row, run by fzf",
+		Run:    CmdFzfPreview,
+		Args:   cobra.ExactArgs(2),
+		Hidden: true,
+	}
+
+	cmdFzf.PersistentFlags().String("picker", "fzf",
+		"Picker backend: fzf or builtin")
+	cmdFzf.PersistentFlags().Int("session", 0,
+		"Picker session of the daemon, which cycles or closes the picker")
+	cmdFzf.PersistentFlags().MarkHidden("session")
+	cmdFzf.AddCommand(cmdFzfSwitcher, cmdFzfSpaceSwitcher, cmdFzfPickWin,
+		cmdFzfPickSpace, cmdFzfPath, cmdFzfPickClip, cmdFzfServe,
+		cmdFzfPreview)
 
 	cmdUserCmd := &cobra.Command{
 		Use:     "usr-cmd",
@@ -97,34 +188,57 @@ func GetRootCmd(logger *log.Logger) *cobra.Command {
 		Run:     CmdUsrCmd,
 		Args:    cobra.ExactArgs(1),
 	}
+	cmdUserCmd.Flags().Bool("dry-run", false,
+		"Print the sway commands instead of running them")
+
+	cmdWarmPicker := &cobra.Command{
+		Use:    "warm-picker",
+		Short:  "Open the warm picker terminal, spawned by the daemon",
+		Run:    CmdWarmPicker,
+		Hidden: true,
+	}
 
 	cmdSwitcher := &cobra.Command{
 		Use:   "switcher",
-		Short: "Show the window switcher window using foot",
-		Long: "Show the window switcher window using foot in the Most Recently " +
+		Short: "Show the window switcher window",
+		Long: "Show the window switcher window in the Most Recently " +
 				"Used order. The list can be traversed by pressing Tab or arrows.",
 		Run: CmdSwitcher,
 	}
+	switcherScopeFlags(cmdSwitcher)
+	pickerFlag(cmdSwitcher)
+
+	cmdSpaceSwitcher := &cobra.Command{
+		Use:   "space-switcher",
+		Short: "Show the workspace switcher window",
+		Long: "Show the workspace switcher window in the Most Recently " +
+				"Used order, with the window count and apps of each workspace.",
+		Run: CmdSpaceSwitcher,
+	}
+	pickerFlag(cmdSpaceSwitcher)
 
 	cmdPickWin := &cobra.Command{
 		Use:   "pick-win",
-		Short: "Show the window picker using foot",
+		Short: "Show the window picker",
 		Run:   CmdPickWin,
 	}
+	pickerFlag(cmdPickWin)
 
 	cmdPickSpace := &cobra.Command{
 		Use:   "pick-space",
-		Short: "Show the workspace picker using foot",
+		Short: "Show the workspace picker",
 		Run:   CmdPickSpace,
 	}
+	pickerFlag(cmdPickSpace)
 
 	cmdPath := &cobra.Command{
 		Use:   "path",
-		Short: "Show the +x files from PATH using foot",
-		Long: "Show the +x files from PATH using foot, with all the dirs being " +
+		Short: "Show the +x files from PATH",
+		Long: "Show the +x files from PATH, with all the dirs being " +
 				"watched for changes.",
 		Run: CmdPath,
 	}
+	pickerFlag(cmdPath)
 
 	cmdWinToSpace := &cobra.Command{
 		Use:   "win-to-space",
@@ -139,44 +253,139 @@ func GetRootCmd(logger *log.Logger) *cobra.Command {
 		Run:   CmdConfig,
 	}
 	mouseFollowsFocusFlag(cmdConfig)
+	cmdConfig.AddCommand(&cobra.Command{
+		Use:     "get KEY",
+		Short:   "Print a config value of the running daemon",
+		Example: "sway-yasm config get daemon.max_tracked",
+		Run:     CmdConfigGet,
+		Args:    cobra.ExactArgs(1),
+	}, &cobra.Command{
+		Use:     "set KEY VALUE",
+		Short:   "Change a config value of the running daemon, until reloaded",
+		Example: "sway-yasm config set switcher.len_apps 40",
+		Run:     CmdConfigSet,
+		Args:    cobra.ExactArgs(2),
+	}, &cobra.Command{
+		Use:   "dump",
+		Short: "Print the whole config of the running daemon as YAML",
+		Run:   CmdConfigDump,
+	})
 
 	cmdClipboard := &cobra.Command{
 		Use:   "clipboard",
 		Short: "Set the clipboard contents from the history",
 		Run:   CmdClipboard,
 	}
+	pickerFlag(cmdClipboard)
+
+	cmdStatus := &cobra.Command{
+		Use:   "status",
+		Short: "Print the status of the running daemon",
+		Long: "Print the status of the running daemon. Exits with 0 when " +
+			"healthy, 2 when it's not connected to sway, 3 when the daemon " +
+			"isn't running and 4 when it's not responding.",
+		Run: CmdStatus,
+	}
+	cmdStatus.Flags().Bool("json", false, "Print as JSON")
+
+	cmdSubscribe := &cobra.Command{
+		Use:   "subscribe",
+		Short: "Print the daemon's events as NDJSON",
+		Long: "Print the daemon's events as NDJSON, one per line, until " +
+			"interrupted. Types: " + strings.Join(daemon.EventTypes, ", ") +
+			". Events are dropped for slow readers, followed by a \"dropped\" " +
+			"event with their count.",
+		Run: CmdSubscribe,
+	}
+	cmdSubscribe.Flags().StringSliceP("type", "t", nil,
+		"Event types to print, all by default")
+
+	cmdWaybar := &cobra.Command{
+		Use:   "waybar MODULE",
+		Short: "Print a waybar custom module on each change",
+		Long: "Print JSON lines of a waybar custom module (\"return-type\": " +
+			"\"json\") on each change of the daemon's state. Modules: " +
+			strings.Join(waybarModuleNames(), ", ") + ".",
+		Args:      cobra.ExactArgs(1),
+		ValidArgs: waybarModuleNames(),
+		Run:       CmdWaybar,
+	}
+	cmdWaybar.Flags().Int("max-len", 40, "Max width of the text")
+	cmdWaybar.Flags().String("workspace", "",
+		"Workspace of the workspaces module, the focused one by default")
 
 	var rootCmd = &cobra.Command{
 		Use: "sway-yasm",
 		Run: CmdRoot,
 	}
-	rootCmd.AddCommand(cmdDaemon, cmdMRUList, cmdSwitcher, cmdPickWin, cmdConfig,
-		cmdPickSpace, cmdPath, cmdUserCmd, cmdWinToSpace, cmdClipboard, cmdFzf)
+	rootCmd.AddCommand(cmdDaemon, cmdMRUList, cmdSwitcher, cmdSpaceSwitcher,
+		cmdPickWin, cmdConfig, cmdPickSpace, cmdPath, cmdUserCmd, cmdWinToSpace,
+		cmdClipboard, cmdStatus, cmdSubscribe, cmdWaybar, cmdReplay, cmdFzf,
+		cmdWarmPicker)
 	rootCmd.Flags().Bool("version", false,
 		"Print version and exit")
+	rootCmd.PersistentFlags().Bool("spawn-daemon", false,
+		"Start the daemon in the background, if not running")
+	rootCmd.PersistentPreRun = func(cmd *cobra.Command, _ []string) {
+		daemon.SpawnDaemon, _ = cmd.Flags().GetBool("spawn-daemon")
+	}
 
 	return rootCmd
 }
 
 func cmdDaemon(logger *log.Logger) func(cmd *cobra.Command, args []string) {
 	return func(cmd *cobra.Command, args []string) {
-		mouseFollow, _ := cmd.Flags().GetBool("mouse-follows-focus")
-		autoconfig, _ := cmd.Flags().GetBool("autoconfig")
-		defaultKeybindings, _ := cmd.Flags().GetBool("default-keybindings")
-		d := &daemon.Daemon{
-			MouseFollowsFocus:  mouseFollow,
-			Autoconfig:         autoconfig,
-			DefaultKeybindings: defaultKeybindings,
-			Logger:             logger,
+		path, _ := cmd.Flags().GetString("config")
+
+		// flags override the config file, also after reloads
+		overrides := map[string]string{}
+		for flag, key := range map[string]string{
+			"mouse-follows-focus": "daemon.mouse_follows_focus",
+			"autoconfig":          "daemon.autoconfig",
+			"default-keybindings": "daemon.default_keybindings",
+			"row-format":          "switcher.row_format",
+		} {
+			if cmd.Flags().Changed(flag) {
+				overrides[key] = cmd.Flags().Lookup(flag).Value.String()
+			}
 		}
-		if mouseFollow {
-			d.Logger.Println("Mouse follows focus enabled")
+
+		record, _ := cmd.Flags().GetString("record")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		d := &daemon.Daemon{
+			Logger:     logger,
+			ConfigPath: path,
+			Overrides:  overrides,
+			RecordPath: record,
+			DryRun:     dryRun,
 		}
 
 		d.Start()
 	}
 }
 
+func cmdReplay(logger *log.Logger) func(cmd *cobra.Command, args []string) {
+	return func(_ *cobra.Command, args []string) {
+		file, err := os.Open(args[0])
+		if err != nil {
+			log.Fatalf("error: %s", err)
+		}
+		defer file.Close()
+
+		result, err := daemon.Replay(file, logger)
+		if err != nil {
+			log.Fatalf("replay error: %s", err)
+		}
+
+		out, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			log.Fatalf("json error: %s", err)
+		}
+		fmt.Println(string(out))
+	}
+}
+
 // ///// ///// /////
 // ///// TERM WRAPPER COMMANDS
 // ///// ///// /////
@@ -184,54 +393,148 @@ func cmdDaemon(logger *log.Logger) func(cmd *cobra.Command, args []string) {
 // TODO open on all visible outputs, as screen session clients
 // use https://github.com/rajveermalviya/go-wayland
 
-func CmdSwitcher(_ *cobra.Command, _ []string) {
-	if !shouldOpen() {
-		log.Fatal("fzf error: already open")
+func CmdSwitcher(cmd *cobra.Command, _ []string) {
+	name := pickerName(cmd)
+
+	// pass the scope flags to the fzf command
+	var flags []string
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		if f.Name != "picker" {
+			flags = append(flags, fmt.Sprintf("--%s=%s", f.Name, f.Value))
+		}
+	})
+
+	p, inTerm := newPicker(name)
+	session := showPicker("switcher", name, inTerm, flags)
+	if session == 0 {
+		return
+	}
+	defer hidePicker(session)
+
+	if !inTerm {
+		switcher(cmd, sessionPicker{p, session})
+		return
 	}
-	_, err := run(shellSwitcher)
+
+	args := append([]string{"switcher", "--picker=" + name,
+		sessionFlag(session)}, flags...)
+	err := runTerminal(args...)
 	if err != nil {
-		log.Fatalf("foot error: %s", err)
+		log.Fatalf("terminal error: %s", err)
 	}
 }
 
-func CmdPickWin(_ *cobra.Command, _ []string) {
-	if !shouldOpen() {
-		log.Fatal("fzf error: already open")
+func CmdSpaceSwitcher(cmd *cobra.Command, _ []string) {
+	name := pickerName(cmd)
+	p, inTerm := newPicker(name)
+	session := showPicker("space-switcher", name, inTerm, nil)
+	if session == 0 {
+		return
+	}
+	defer hidePicker(session)
+
+	if !inTerm {
+		spaceSwitcher(sessionPicker{p, session})
+		return
 	}
-	_, err := run(shellPickWin)
+
+	err := runTerminal("space-switcher", "--picker="+name,
+		sessionFlag(session))
 	if err != nil {
-		log.Fatal("foot error: " + err.Error())
+		log.Fatalf("terminal error: %s", err)
 	}
 }
 
-func CmdPickSpace(_ *cobra.Command, _ []string) {
-	if !shouldOpen() {
-		log.Fatal("fzf error: already open")
+func CmdPickWin(cmd *cobra.Command, _ []string) {
+	name := pickerName(cmd)
+	p, inTerm := newPicker(name)
+	session := showPicker("pick-win", name, inTerm, nil)
+	if session == 0 {
+		return
+	}
+	defer hidePicker(session)
+
+	if !inTerm {
+		pickWin(sessionPicker{p, session})
+		return
 	}
-	_, err := run(shellPickSpace)
+
+	err := runTerminal("pick-win", "--picker="+name,
+		sessionFlag(session))
 	if err != nil {
-		log.Fatalf("foot error: %s", err)
+		log.Fatal("terminal error: " + err.Error())
 	}
 }
 
-func CmdPath(_ *cobra.Command, _ []string) {
-	if !shouldOpen() {
-		log.Fatal("fzf error: already open")
+func CmdPickSpace(cmd *cobra.Command, _ []string) {
+	name := pickerName(cmd)
+	p, inTerm := newPicker(name)
+	session := showPicker("pick-space", name, inTerm, nil)
+	if session == 0 {
+		return
 	}
-	_, err := run(shellPath)
+	defer hidePicker(session)
+
+	if !inTerm {
+		pickSpace(sessionPicker{p, session})
+		return
+	}
+
+	err := runTerminal("pick-space", "--picker="+name,
+		sessionFlag(session))
 	if err != nil {
-		log.Fatalf("foot error: %s", err)
+		log.Fatalf("terminal error: %s", err)
 	}
 }
 
-func CmdClipboard(_ *cobra.Command, _ []string) {
-	if !shouldOpen() {
-		log.Fatal("fzf error: already open")
+func CmdPath(cmd *cobra.Command, _ []string) {
+	name := pickerName(cmd)
+	p, inTerm := newPicker(name)
+	session := showPicker("path", name, inTerm, nil)
+	if session == 0 {
+		return
 	}
+	defer hidePicker(session)
 
-	_, err := run(shellClipboard)
+	if !inTerm {
+		pickPath(sessionPicker{p, session})
+		return
+	}
+
+	err := runTerminal("path", "--picker="+name,
+		sessionFlag(session))
 	if err != nil {
-		log.Fatalf("foot error: %s", err)
+		log.Fatalf("terminal error: %s", err)
+	}
+}
+
+func CmdClipboard(cmd *cobra.Command, _ []string) {
+	name := pickerName(cmd)
+	p, inTerm := newPicker(name)
+	session := showPicker("clipboard", name, inTerm, nil)
+	if session == 0 {
+		return
+	}
+	defer hidePicker(session)
+
+	if !inTerm {
+		pickClipboard(sessionPicker{p, session})
+		return
+	}
+
+	err := runTerminal("clipboard", "--picker="+name,
+		sessionFlag(session))
+	if err != nil {
+		log.Fatalf("terminal error: %s", err)
+	}
+}
+
+// CmdWarmPicker opens the terminal of "fzf serve", which registers itself as
+// the warm picker.
+func CmdWarmPicker(_ *cobra.Command, _ []string) {
+	err := runTerminal("serve", "--picker="+getConfig().Picker.Backend)
+	if err != nil {
+		log.Fatalf("terminal error: %s", err)
 	}
 }
 
@@ -264,22 +567,33 @@ func CmdRoot(cmd *cobra.Command, _ []string) {
 	}
 }
 
-func CmdUsrCmd(_ *cobra.Command, args []string) {
+func CmdUsrCmd(cmd *cobra.Command, args []string) {
 	usrArgs := ""
 	if len(args) > 1 {
 		usrArgs = strings.Join(args[1:], " ")
 	}
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
 
-	result, err := daemon.RemoteCall("Daemon.RemoteUsrCmd", daemon.RPCArgs{
-		UsrCmd:  args[0],
-		UsrArgs: usrArgs,
-	})
+	var reply daemon.UsrCmdReply
+	err := daemon.RemoteCall("Daemon.RemoteUsrCmd", daemon.UsrCmdArgs{
+		Name:   args[0],
+		Args:   usrArgs,
+		DryRun: dryRun,
+	}, &reply)
 	if err != nil {
-		fmt.Printf("error: %s", err)
+		fatalRPC(err)
 	}
 
 	// TODO allow for fzf
-	fmt.Printf(result)
+	fmt.Printf(reply.Output)
+	if reply.Output != "" && len(reply.Commands) > 0 &&
+		!strings.HasSuffix(reply.Output, "\n") {
+
+		fmt.Println()
+	}
+	for _, c := range reply.Commands {
+		fmt.Println(c)
+	}
 }
 
 func CmdWinToSpace(_ *cobra.Command, args []string) {
@@ -288,38 +602,190 @@ func CmdWinToSpace(_ *cobra.Command, args []string) {
 		log.Fatalf("error: %s", err)
 	}
 
-	_, err = daemon.RemoteCall("Daemon.RemoteWinToSpace", daemon.RPCArgs{
+	err = daemon.RemoteCall("Daemon.RemoteWinToSpace", daemon.WinToSpaceArgs{
 		SpaceNum: id,
-	})
+	}, &daemon.Empty{})
 	if err != nil {
-		log.Fatalf("rpc error: %s", err)
+		fatalRPC(err)
 	}
 }
 
 func CmdConfig(cmd *cobra.Command, _ []string) {
+	if !cmd.Flags().Changed("mouse-follows-focus") {
+		_ = cmd.Help()
+		return
+	}
+
 	mouseFollow, _ := cmd.Flags().GetBool("mouse-follows-focus")
-	result, err := daemon.RemoteCall("Daemon.RemoteSetConfig", daemon.RPCArgs{
-		MouseFollowsFocus: mouseFollow,
-	})
+	err := daemon.RemoteCall("Daemon.RemoteSetConfig", daemon.ConfigArgs{
+		Key:   "daemon.mouse_follows_focus",
+		Value: strconv.FormatBool(mouseFollow),
+	}, &daemon.Empty{})
 	if err != nil {
-		log.Fatalf("rpc error: %s", err)
+		fatalRPC(err)
 	}
 
-	if result != "" {
-		log.Fatal("config error")
-	}
 	fmt.Println("Config updated:")
 	fmt.Printf("- mouse follows focus: %t\n", mouseFollow)
-	// TODO print out the current config (as yaml)
+}
+
+func CmdConfigGet(_ *cobra.Command, args []string) {
+	var reply daemon.ConfigReply
+	err := daemon.RemoteCall("Daemon.RemoteGetConfig", daemon.ConfigArgs{
+		Key: args[0],
+	}, &reply)
+	if err != nil {
+		fatalRPC(err)
+	}
+
+	fmt.Println(reply.YAML)
+}
+
+func CmdConfigSet(_ *cobra.Command, args []string) {
+	err := daemon.RemoteCall("Daemon.RemoteSetConfig", daemon.ConfigArgs{
+		Key:   args[0],
+		Value: args[1],
+	}, &daemon.Empty{})
+	if err != nil {
+		fatalRPC(err)
+	}
+}
+
+func CmdConfigDump(_ *cobra.Command, _ []string) {
+	var reply daemon.ConfigReply
+	err := daemon.RemoteCall("Daemon.RemoteGetConfig", daemon.ConfigArgs{},
+		&reply)
+	if err != nil {
+		fatalRPC(err)
+	}
+
+	fmt.Print(reply.YAML)
+}
+
+func CmdSubscribe(cmd *cobra.Command, _ []string) {
+	kinds, _ := cmd.Flags().GetStringSlice("type")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt,
+		syscall.SIGTERM)
+	defer stop()
+
+	err := daemon.Subscribe(ctx, kinds, os.Stdout)
+	if err != nil {
+		fatalRPC(err)
+	}
+}
+
+func CmdStatus(cmd *cobra.Command, _ []string) {
+	var reply daemon.StatusReply
+	err := daemon.RemoteCall("Daemon.RemoteStatus", daemon.Empty{}, &reply)
+	if err != nil {
+		fatalRPC(err)
+	}
+
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		printStatusJSON(&reply)
+	} else {
+		printStatus(&reply)
+	}
+
+	if !reply.Healthy() {
+		os.Exit(exitNoSway)
+	}
+}
+
+// printStatusJSON prints the status with the config keys as in the config
+// file.
+func printStatusJSON(reply *daemon.StatusReply) {
+	// the config has only yaml tags
+	var cfg map[string]any
+	out, err := yaml.Marshal(reply.Config)
+	if err == nil {
+		err = yaml.Unmarshal(out, &cfg)
+	}
+	if err != nil {
+		log.Fatalf("yaml error: %s", err)
+	}
+
+	out, err = json.Marshal(struct {
+		daemon.Status
+		Config map[string]any `json:"config"`
+	}{reply.Status, cfg})
+	if err != nil {
+		log.Fatalf("json error: %s", err)
+	}
+	fmt.Println(string(out))
+}
+
+func printStatus(status *daemon.StatusReply) {
+	onOff := func(b bool, on, off string) string {
+		if b {
+			return on
+		}
+		return off
+	}
+	var listeners []string
+	for event, count := range status.Listeners {
+		listeners = append(listeners, fmt.Sprintf("%s (%d)", event, count))
+	}
+	slices.Sort(listeners)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "daemon:\tPID %d, %s, up %s\n", status.PID, status.Version,
+		time.Since(status.StartedAt).Round(time.Second))
+	fmt.Fprintf(w, "sway:\t%s, %s\n",
+		onOff(status.Connected, "connected", "disconnected"), status.SwaySock)
+	if status.DryRun {
+		fmt.Fprintf(w, "dry run:\ton\n")
+	}
+	fmt.Fprintf(w, "windows:\t%d\n", status.Windows)
+	fmt.Fprintf(w, "workspaces:\t%d\n", status.Workspaces)
+	fmt.Fprintf(w, "outputs:\t%d\n", status.Outputs)
+	fmt.Fprintf(w, "path index:\t%s\n",
+		onOff(status.PathReady, "ready", "refreshing"))
+	fmt.Fprintf(w, "clipman:\t%s\n",
+		onOff(status.Clipman, "running", "not running"))
+	fmt.Fprintf(w, "mouse follows focus:\t%s\n",
+		onOff(status.MouseFollowsFocus, "on", "off"))
+	fmt.Fprintf(w, "user commands:\t%s\n", strings.Join(status.UsrCmds, ", "))
+	fmt.Fprintf(w, "listeners:\t%s\n", strings.Join(listeners, ", "))
+	fmt.Fprintf(w, "subscribers:\t%d\n", status.Subscribers)
+	w.Flush()
+
+	// nest the config
+	var cfg bytes.Buffer
+	enc := yaml.NewEncoder(&cfg)
+	enc.SetIndent(2)
+	err := enc.Encode(map[string]any{"config": status.Config})
+	if err != nil {
+		log.Fatalf("yaml error: %s", err)
+	}
+	fmt.Printf("\n%s", cfg.String())
 }
 
 // ///// ///// /////
 // ///// HELPERS
 // ///// ///// /////
 
+// fatalRPC exits with a code depending on the RPC error.
+func fatalRPC(err error) {
+	code := exitErr
+	switch {
+	case errors.Is(err, daemon.ErrSwayUnavailable):
+		code = exitNoSway
+	case errors.Is(err, daemon.ErrDaemonNotRunning):
+		code = exitNoDaemon
+	case errors.Is(err, daemon.ErrDaemonTimeout):
+		code = exitNoReply
+	case errors.Is(err, daemon.ErrProtocolMismatch):
+		code = exitProtocol
+	}
+
+	log.Printf("rpc error: %s", err)
+	os.Exit(code)
+}
+
 func matchSuffixID(result string) (int, error) {
 	re := regexp.MustCompile(`\((\d+)\)\s*$`)
-	match := re.FindStringSubmatch(result)
+	match := re.FindStringSubmatch(ansiEscape.ReplaceAllString(result, ""))
 	if len(match) == 0 {
 		return 0, fmt.Errorf("no (ID) match")
 	}
@@ -337,28 +803,72 @@ func matchPrefixID(result string) (int, error) {
 	return strconv.Atoi(match[1])
 }
 
-func shouldOpen() bool {
-	pid := os.Getpid()
-	shouldOpen, err := daemon.RemoteCall("Daemon.RemoteShouldOpen", daemon.RPCArgs{PID: pid})
+// switcherScope returns the scope flags as RPC args, and the grouping flag.
+func switcherScope(cmd *cobra.Command) (daemon.WindowsArgs, bool) {
+	outputOnly, _ := cmd.Flags().GetBool("current-output-only")
+	spaceOnly, _ := cmd.Flags().GetBool("current-space-only")
+	groupBy, _ := cmd.Flags().GetBool("group-by-output")
+
+	return daemon.WindowsArgs{
+		CurrentOutputOnly: outputOnly,
+		CurrentSpaceOnly:  spaceOnly,
+	}, groupBy
+}
+
+// showPicker starts a picker session in the daemon and returns its ID, if
+// the caller should open the picker. Returns 0 if the daemon showed the warm
+// picker, or cycled or closed the open one instead.
+func showPicker(kind, name string, term bool, args []string) int {
+	var reply daemon.PickerShowReply
+	err := daemon.RemoteCall("Daemon.RemotePickerShow", daemon.PickerShowArgs{
+		Picker:  kind,
+		Args:    args,
+		Backend: name,
+		Term:    term,
+		PID:     os.Getpid(),
+	}, &reply)
+	if err != nil {
+		fatalRPC(err)
+	}
+	if !reply.Open {
+		return 0
+	}
+
+	return reply.Session
+}
+
+// hidePicker ends the session of showPicker.
+func hidePicker(session int) {
+	err := daemon.RemoteCall("Daemon.RemotePickerHide", daemon.PickerHideArgs{
+		Session: session,
+		PID:     os.Getpid(),
+	}, &daemon.Empty{})
 	if err != nil {
 		log.Printf("rpc error: %s", err)
-		return false
 	}
+}
 
-	return shouldOpen == "true"
+// sessionFlag passes the session to the fzf commands.
+func sessionFlag(session int) string {
+	return fmt.Sprintf("--session=%d", session)
 }
 
-func runFZF(cmd string, input *string) (string, error) {
+// runFZF runs the fzf shell snippet with the input, and the env vars added to
+// the current ones.
+func runFZF(cmd string, input *string, env ...string) (string, error) {
 	shell := os.Getenv("SHELL")
 	if len(shell) == 0 {
 		shell = "sh"
 	}
 	if daemon.IsLightMode() {
-		cmd = strings.TrimRight(cmd, " \n") + shellFzfLight
+		cmd = strings.TrimRight(cmd, " \n") + getConfig().Fzf.Light
 	}
 
 	fzf := exec.Command(shell, "-c", cmd)
 	fzf.Stdin = bytes.NewBuffer([]byte(*input))
+	if len(env) > 0 {
+		fzf.Env = append(os.Environ(), env...)
+	}
 
 	// bind the UI
 	fzf.Stderr = os.Stderr
@@ -0,0 +1,156 @@
+package cmds
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"golang.org/x/text/width"
+
+	"github.com/pancsta/sway-yasm/internal/types"
+)
+
+const (
+	ansiReset     = "\x1b[0m"
+	ansiBold      = "\x1b[1m"
+	ansiUnderline = "\x1b[4m"
+)
+
+// rowData is passed to the row template. Besides the window's data, it
+// includes derived fields.
+type rowData struct {
+	types.WindowData
+	// OutputAlias is a shortened output name, eg "H-1" for "HEADLESS-1".
+	OutputAlias string
+	// Marks are single letter flags: F(loating), M(aximized), U(rgent).
+	Marks string
+}
+
+// rowFormatter renders window rows using a user-defined template. The ID
+// suffix is always appended, as the pickers depend on it.
+type rowFormatter struct {
+	tpl *template.Template
+}
+
+// rowFuncs implement config.RowFuncs.
+var rowFuncs = template.FuncMap{
+	"pad":   pad,
+	"trunc": truncate,
+	"width": displayWidth,
+}
+
+func newRowFormatter(format string) (*rowFormatter, error) {
+	tpl, err := template.New("row").Funcs(rowFuncs).Parse(format)
+	if err != nil {
+		return nil, fmt.Errorf("row format: %w", err)
+	}
+
+	return &rowFormatter{tpl: tpl}, nil
+}
+
+// text renders the row without the ID suffix.
+func (f *rowFormatter) text(win types.WindowData) (string, error) {
+	data := rowData{
+		WindowData:  win,
+		OutputAlias: strings.Replace(win.Output, "HEADLESS-", "H-", 1),
+	}
+	if win.Floating {
+		data.Marks += "F"
+	}
+	if win.Fullscreen {
+		data.Marks += "M"
+	}
+	if win.Urgent {
+		data.Marks += "U"
+	}
+
+	var buf bytes.Buffer
+	if err := f.tpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	// rows are single line
+	return strings.ReplaceAll(buf.String(), "\n", " "), nil
+}
+
+// row renders the row with the ID suffix, optionally wrapped in an ANSI style.
+func (f *rowFormatter) row(win types.WindowData, style string) string {
+	text, err := f.text(win)
+	if err != nil {
+		text = err.Error()
+	}
+	if style != "" {
+		text = style + text + ansiReset
+	}
+
+	return fmt.Sprintf("%s (%d) \n", text, win.ID)
+}
+
+// ///// ///// /////
+// ///// WIDTH
+// ///// ///// /////
+
+// runeWidth returns the number of terminal cells used by the rune.
+func runeWidth(r rune) int {
+	switch {
+	case r == 0 || unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		return 0
+	case !unicode.IsPrint(r):
+		return 0
+	}
+
+	switch width.LookupRune(r).Kind() {
+	case width.EastAsianWide, width.EastAsianFullwidth:
+		return 2
+	}
+
+	return 1
+}
+
+// displayWidth returns the number of terminal cells used by the string.
+func displayWidth(s string) int {
+	w := 0
+	for _, r := range s {
+		w += runeWidth(r)
+	}
+
+	return w
+}
+
+// truncate shortens the string to the max display width, ending with "..."
+// when there's enough space.
+func truncate(maxWidth int, s string) string {
+	if displayWidth(s) <= maxWidth {
+		return s
+	}
+
+	suffix := ""
+	if maxWidth > 4 {
+		suffix = "..."
+	}
+	limit := maxWidth - len(suffix)
+
+	w := 0
+	var b strings.Builder
+	for _, r := range s {
+		rw := runeWidth(r)
+		if w+rw > limit {
+			break
+		}
+		w += rw
+		b.WriteRune(r)
+	}
+
+	return b.String() + suffix
+}
+
+// pad truncates the string and pads it with spaces to the exact display
+// width. Negative widths mean 0.
+func pad(maxWidth int, s string) string {
+	maxWidth = max(0, maxWidth)
+	s = truncate(maxWidth, s)
+
+	return s + strings.Repeat(" ", max(0, maxWidth-displayWidth(s)))
+}
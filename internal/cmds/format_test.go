@@ -0,0 +1,47 @@
+package cmds
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/pancsta/sway-yasm/internal/config"
+)
+
+func TestPad(t *testing.T) {
+	tests := []struct {
+		width int
+		s     string
+		want  string
+	}{
+		{5, "foo", "foo  "},
+		{3, "foo", "foo"},
+		{0, "foo", ""},
+		{-1, "foo", ""},
+		{-1, "", ""},
+		{2, "foot", "fo"},
+		{8, "firefox browser", "firef..."},
+		// wide runes take 2 cells
+		{4, "漢字", "漢字"},
+		{3, "漢字", "漢 "},
+		{1, "漢", " "},
+	}
+	for _, tt := range tests {
+		if got := pad(tt.width, tt.s); got != tt.want {
+			t.Errorf("pad(%d, %q) = %q, want %q", tt.width, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestRowFuncs(t *testing.T) {
+	// validated by the config
+	var names []string
+	for name := range rowFuncs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	want := slices.Clone(config.RowFuncs)
+	slices.Sort(want)
+	if !slices.Equal(names, want) {
+		t.Errorf("row funcs %q, config %q", names, want)
+	}
+}
@@ -3,149 +3,435 @@ package cmds
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/pancsta/sway-yasm/internal/config"
 	"github.com/pancsta/sway-yasm/internal/daemon"
+	"github.com/pancsta/sway-yasm/internal/types"
 	"github.com/spf13/cobra"
 	"log"
 	"os"
 	"os/exec"
 	"slices"
 	"strings"
-)
-
-// TODO gen fzf.yml for user overrides
-
-const (
-	shellFzf = `
-  fzf \
-    --prompt 'Switcher: ' \
-    --bind "load:pos(2)" \
-    --bind "change:pos(1)" \
-    --layout=reverse --info=hidden \
-    --bind=space:accept,tab:offset-down,btab:offset-up
-`
-	shellFzfPickWin = `
-  fzf \
-    --prompt 'Move which window to this workspace?: ' \
-    --layout=reverse --info=hidden \
-    --bind=space:accept,tab:offset-down,btab:offset-up
-`
-	shellFzfClipboard = `
-  fzf \
-    --prompt 'Copy which one to the clipboard?: ' \
-    --layout=reverse --info=hidden \
-    --bind=space:accept,tab:offset-down,btab:offset-up
-`
-	shellFzfPickSpace = `
-  fzf \
-    --prompt 'Move which workspace to this output?: ' \
-    --layout=reverse --info=hidden \
-    --bind=space:accept,tab:offset-down,btab:offset-up
-`
-	shellFzfPath = `
-  fzf \
-    --prompt 'Run: ' \
-    --layout=reverse --info=hidden \
-    --bind=space:accept,tab:offset-down,btab:offset-up
-`
-	// junegunn/seoul256.vim (light)
-	shellFzfLight = ` \
-    --color=bg+:#D9D9D9,bg:#E1E1E1,border:#C8C8C8,spinner:#719899,hl:#719872,fg:#616161,header:#719872,info:#727100,pointer:#E12672,marker:#E17899,fg+:#616161,preview-bg:#D9D9D9,prompt:#0099BD,hl+:#719899
-`
-	shellSwitcher = `
-    foot --title "sway-yasm" sway-yasm fzf switcher
-`
-	shellPickWin = `
-    foot --title "sway-yasm" sway-yasm fzf pick-win
-`
-	shellPickSpace = `
-    foot --title "sway-yasm" sway-yasm fzf pick-space
-`
-	shellPath = `
-    foot --title "sway-yasm" sway-yasm fzf path
-`
-	shellClipboard = `
-    foot --title "sway-yasm" sway-yasm fzf clipboard
-`
+	"time"
 )
 
 // ///// ///// /////
 // ///// FZF COMMANDS
 // ///// ///// /////
 
-func CmdFzfSwitcher(_ *cobra.Command, _ []string) {
-	// req the daemon
-	input, err := daemon.RemoteCall("Daemon.RemoteFZFList", daemon.RPCArgs{})
+func CmdFzfSwitcher(cmd *cobra.Command, _ []string) {
+	switcher(cmd, termPicker(cmd))
+}
+
+func CmdFzfSpaceSwitcher(cmd *cobra.Command, _ []string) {
+	spaceSwitcher(termPicker(cmd))
+}
+
+func CmdFzfPickWin(cmd *cobra.Command, _ []string) {
+	pickWin(termPicker(cmd))
+}
+
+func CmdFzfPickSpace(cmd *cobra.Command, _ []string) {
+	pickSpace(termPicker(cmd))
+}
+
+func CmdFzfClipboard(cmd *cobra.Command, _ []string) {
+	pickClipboard(termPicker(cmd))
+}
+
+func CmdFzfPath(cmd *cobra.Command, _ []string) {
+	pickPath(termPicker(cmd))
+}
+
+// CmdFzfServe runs the warm picker, which shows the sessions of
+// Daemon.RemotePickerShow, until its window gets closed.
+func CmdFzfServe(cmd *cobra.Command, _ []string) {
+	name, _ := cmd.Flags().GetString("picker")
+	args := daemon.PickerServeArgs{PID: os.Getpid(), Backend: name,
+		Poll: daemon.ClientTimeout() / 2}
+	exe, err := os.Executable()
 	if err != nil {
-		log.Fatalf("rpc error: %s", err)
+		log.Fatalf("error: %s", err)
 	}
 
-	// run fzf
-	result, err := runFZF(shellFzf, &input)
+	// the window may not be mapped yet
+	for i := 0; i < 30; i++ {
+		err = daemon.RemoteCall("Daemon.RemotePickerServe", args,
+			&daemon.Empty{})
+		if err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
 	if err != nil {
-		log.Fatalf("fzf error: %s", err)
+		fatalRPC(err)
+	}
+
+	for {
+		var next daemon.PickerNextReply
+		err := daemon.RemoteCall("Daemon.RemotePickerNext", args, &next)
+		if err != nil {
+			fatalRPC(err)
+		}
+		if next.Picker == "" {
+			continue
+		}
+
+		// a new process per session, as the pickers exit when done
+		pickerArgs := append([]string{"fzf", next.Picker, "--picker=" + name,
+			sessionFlag(next.Session)}, next.Args...)
+		picker := exec.Command(exe, pickerArgs...)
+		picker.Stdin = os.Stdin
+		picker.Stdout = os.Stdout
+		picker.Stderr = os.Stderr
+		if err := picker.Run(); err != nil {
+			log.Printf("picker error: %s", err)
+		}
+
+		// clear the screen before hiding
+		fmt.Print("\x1b[H\x1b[2J")
+		err = daemon.RemoteCall("Daemon.RemotePickerHide",
+			daemon.PickerHideArgs{Session: next.Session, PID: args.PID},
+			&daemon.Empty{})
+		if err != nil {
+			fatalRPC(err)
+		}
+	}
+}
+
+// CmdFzfPreview prints the preview of a row, for fzf's --preview, see
+// writePreviews.
+func CmdFzfPreview(_ *cobra.Command, args []string) {
+	fmt.Print(readPreview(args[0], args[1]))
+}
+
+// termPicker returns the picker of --picker, which isn't a dmenu one,
+// attached to the --session.
+func termPicker(cmd *cobra.Command) picker {
+	name, _ := cmd.Flags().GetString("picker")
+	p, inTerm := newPicker(name)
+	if !inTerm {
+		log.Fatalf("error: %s doesn't run in a terminal", name)
+	}
+	if session, _ := cmd.Flags().GetInt("session"); session != 0 {
+		return sessionPicker{p, session}
 	}
 
-	// match the window's ID at the end of the line
-	winID, err := matchSuffixID(result)
+	return p
+}
+
+// ///// ///// /////
+// ///// PICKERS
+// ///// ///// /////
+
+func switcher(cmd *cobra.Command, p picker) {
+	args, groupBy := switcherScope(cmd)
+
+	// req the daemon
+	var reply daemon.WindowsReply
+	err := daemon.RemoteCall("Daemon.RemoteWindows", args, &reply)
+	if err != nil {
+		fatalRPC(err)
+	}
+	rows, err := newRowFormatter(getConfig().Switcher.RowFormat)
 	if err != nil {
 		log.Fatalf("error: %s", err)
 	}
+	input := switcherRows(rows, &reply, groupBy)
+
+	opts := pickOpts{
+		fzf:     getConfig().Fzf.Switcher,
+		prompt:  "Switcher: ",
+		pos:     2,
+		id:      idSuffix,
+		preview: winPreview(reply.Windows),
+	}
+	// group headers shift the previous window
+	if groupBy {
+		opts.pos = prevWinPos(input, reply.Windows)
+	}
+
+	// run the picker
+	winID, err := pickSuffixID(p, input, opts)
+	if err != nil {
+		log.Fatalf("picker error: %s", err)
+	}
 
 	// focus the window
-	_, err = daemon.RemoteCall("Daemon.RemoteFocusWinID", daemon.RPCArgs{WinID: winID})
+	err = daemon.RemoteCall("Daemon.RemoteFocusWinID",
+		daemon.WinArgs{ID: winID}, &daemon.Empty{})
 	if err != nil {
-		log.Fatalf("rpc error: %s", err)
+		fatalRPC(err)
+	}
+}
+
+// switcherRows renders the windows as fzf input, optionally grouped by
+// output.
+func switcherRows(
+	rows *rowFormatter, reply *daemon.WindowsReply, groupBy bool,
+) string {
+	row := func(win types.WindowData) string {
+		// highlight already visible windows
+		style := ""
+		switch {
+		case win.Workspace == reply.Space.Name:
+			style = ansiUnderline
+		case slices.Contains(reply.Visible, win.Workspace):
+			style = ansiBold
+		}
+
+		return rows.row(win, style)
+	}
+
+	ret := ""
+	if !groupBy {
+		for _, win := range reply.Windows {
+			ret += row(win)
+		}
+		return ret
+	}
+
+	// group by output, starting with the current one, MRU order within groups
+	outputs := []string{reply.Space.Output}
+	for _, win := range reply.Windows {
+		if !slices.Contains(outputs, win.Output) {
+			outputs = append(outputs, win.Output)
+		}
+	}
+	for _, output := range outputs {
+		header := false
+		for _, win := range reply.Windows {
+			if win.Output != output {
+				continue
+			}
+			if !header {
+				ret += fmt.Sprintf("-- %s --\n", output)
+				header = true
+			}
+			ret += row(win)
+		}
+	}
+
+	return ret
+}
+
+// pickSuffixID picks a row and matches its ID at the end of the line. Rows
+// without an ID, eg the group headers, show the picker again, with the row
+// below preselected.
+func pickSuffixID(p picker, input string, opts pickOpts) (int, error) {
+	lines := strings.Split(ansiEscape.ReplaceAllString(input, ""), "\n")
+	for {
+		result, err := p.pick(input, opts)
+		if err != nil {
+			return 0, err
+		}
+		id, err := matchSuffixID(result)
+		if err == nil {
+			return id, nil
+		}
+
+		log.Printf("picked %q without an ID, showing again", result)
+		row := strings.TrimRight(ansiEscape.ReplaceAllString(result, ""), " ")
+		i := slices.IndexFunc(lines, func(line string) bool {
+			return strings.TrimRight(line, " ") == row
+		})
+		if i < 0 {
+			return 0, err
+		}
+		// 1-based, the row below
+		opts.pos = i + 2
+	}
+}
+
+// prevWinPos returns the 1-based line number of the previous window in the
+// list, or 2.
+func prevWinPos(list string, mru []types.WindowData) int {
+	if len(mru) < 2 {
+		return 2
+	}
+
+	for i, line := range strings.Split(list, "\n") {
+		id, err := matchSuffixID(line)
+		if err == nil && id == mru[1].ID {
+			return i + 1
+		}
 	}
+
+	return 2
 }
 
-func CmdFzfPickWin(_ *cobra.Command, _ []string) {
+func spaceSwitcher(p picker) {
 	// req the daemon
-	input, err := daemon.RemoteCall("Daemon.RemoteFZFListPickWin", daemon.RPCArgs{})
+	var reply daemon.SpacesReply
+	err := daemon.RemoteCall("Daemon.RemoteSpaces", daemon.SpacesArgs{},
+		&reply)
 	if err != nil {
-		log.Fatalf("rpc error: %s", err)
+		fatalRPC(err)
+	}
+
+	input := ""
+	cfg := getConfig().Switcher
+	for _, space := range reply.Spaces {
+		display := strings.Replace(space.Output, "HEADLESS-", "H-", 1)
+		input += fmt.Sprintf("%s | %s | %2d | %s (%d) \n",
+			pad(cfg.LenDisplay, display),
+			pad(cfg.LenSpace, space.Name),
+			space.Windows,
+			pad(cfg.LenApps, strings.Join(space.Apps, ", ")),
+			space.ID,
+		)
 	}
-	// run fzf
-	result, err := runFZF(shellFzfPickWin, &input)
+
+	// run the picker
+	result, err := p.pick(input, pickOpts{
+		fzf:    getConfig().Fzf.SpaceSwitcher,
+		prompt: "Workspace: ",
+		pos:    2,
+		id:     idSuffix,
+		preview: func(row string) string {
+			id, _ := matchSuffixID(row)
+			for _, space := range reply.Spaces {
+				if space.ID == id {
+					return strings.Join(space.Apps, "\n")
+				}
+			}
+			return ""
+		},
+	})
 	if err != nil {
-		log.Fatalf("fzf error: %s", err)
+		log.Fatalf("picker error: %s", err)
 	}
 
-	// match the window's ID at the end of the line
-	winID, err := matchSuffixID(result)
+	// match the workspace's ID at the end of the line
+	spaceID, err := matchSuffixID(result)
 	if err != nil {
 		log.Fatalf("error: %s", err)
 	}
 
-	// move the window to the current workspace
-	_, err = daemon.RemoteCall("Daemon.RemoteMoveWinToSpace", daemon.RPCArgs{WinID: winID})
+	// focus the workspace
+	err = daemon.RemoteCall("Daemon.RemoteFocusSpace",
+		daemon.SpaceArgs{ID: spaceID}, &daemon.Empty{})
 	if err != nil {
-		log.Fatalf("rpc error: %s", err)
+		fatalRPC(err)
 	}
 }
 
-func CmdFzfPickSpace(_ *cobra.Command, _ []string) {
+func pickWin(p picker) {
 	// req the daemon
-	list, err := daemon.RemoteCall("Daemon.RemoteFZFListPickSpace", daemon.RPCArgs{})
+	var reply daemon.WindowsReply
+	err := daemon.RemoteCall("Daemon.RemoteWindows", daemon.WindowsArgs{},
+		&reply)
+	if err != nil {
+		fatalRPC(err)
+	}
+	rows, err := newRowFormatter(getConfig().Switcher.RowFormat)
 	if err != nil {
-		log.Fatalf("rpc error: %s", err)
+		log.Fatalf("error: %s", err)
+	}
+	input := ""
+	for _, win := range reply.Windows {
+		// skip same workspace
+		if win.Workspace == reply.Space.Name {
+			continue
+		}
+		input += rows.row(win, "")
 	}
 
-	// run fzf to pick the workspace
-	result, err := runFZF(shellFzfPickSpace, &list)
+	// run the picker
+	result, err := p.pick(input, pickOpts{
+		fzf:     getConfig().Fzf.PickWin,
+		prompt:  "Move which window to this workspace?: ",
+		id:      idSuffix,
+		multi:   true,
+		preview: winPreview(reply.Windows),
+	})
 	if err != nil {
-		log.Fatalf("fzf error: %s", err)
+		log.Fatalf("picker error: %s", err)
 	}
 
-	// move the workspace to the current output
-	_, err = daemon.RemoteCall("Daemon.RemoteMoveSpaceToOutput", daemon.RPCArgs{
-		Workspace: strings.Trim(result, " \n"),
+	for _, line := range strings.Split(strings.TrimRight(result, "\n"), "\n") {
+		// match the window's ID at the end of the line
+		winID, err := matchSuffixID(line)
+		if err != nil {
+			log.Fatalf("error: %s", err)
+		}
+
+		// move the window to the current workspace
+		err = daemon.RemoteCall("Daemon.RemoteMoveWinToSpace",
+			daemon.WinArgs{ID: winID}, &daemon.Empty{})
+		if err != nil {
+			fatalRPC(err)
+		}
+	}
+}
+
+// winPreview returns a preview of the window's details, for the rows ending
+// with its ID.
+func winPreview(wins []types.WindowData) func(row string) string {
+	return func(row string) string {
+		id, err := matchSuffixID(row)
+		if err != nil {
+			return ""
+		}
+		for _, win := range wins {
+			if win.ID != id {
+				continue
+			}
+
+			var flags []string
+			if win.Floating {
+				flags = append(flags, "floating")
+			}
+			if win.Fullscreen {
+				flags = append(flags, "fullscreen")
+			}
+			if win.Urgent {
+				flags = append(flags, "urgent")
+			}
+
+			return fmt.Sprintf("%s\n\napp: %s\nworkspace: %s\noutput: %s\n"+
+				"size: %dx%d %s\nid: %d", win.Title, win.App, win.Workspace,
+				win.Output, win.Rect.Width, win.Rect.Height,
+				strings.Join(flags, ", "), win.ID)
+		}
+
+		return ""
+	}
+}
+
+func pickSpace(p picker) {
+	// req the daemon
+	var reply daemon.SpacesReply
+	err := daemon.RemoteCall("Daemon.RemoteSpaces",
+		daemon.SpacesArgs{SkipCurrentOutput: true}, &reply)
+	if err != nil {
+		fatalRPC(err)
+	}
+	var names []string
+	for _, space := range reply.Spaces {
+		names = append(names, space.Name)
+	}
+	list := strings.Join(names, "\n")
+
+	// run the picker to pick the workspace
+	result, err := p.pick(list, pickOpts{
+		fzf:    getConfig().Fzf.PickSpace,
+		prompt: "Move which workspace to this output?: ",
 	})
 	if err != nil {
-		log.Fatalf("rpc error: %s", err)
+		log.Fatalf("picker error: %s", err)
+	}
+
+	// move the workspace to the current output
+	err = daemon.RemoteCall("Daemon.RemoteMoveSpaceToOutput",
+		daemon.MoveSpaceArgs{Workspace: strings.Trim(result, " \n")},
+		&daemon.Empty{})
+	if err != nil {
+		fatalRPC(err)
 	}
 }
 
-func CmdFzfClipboard(_ *cobra.Command, _ []string) {
+func pickClipboard(p picker) {
 	shell := os.Getenv("SHELL")
 	if len(shell) == 0 {
 		shell = "sh"
@@ -166,21 +452,32 @@ func CmdFzfClipboard(_ *cobra.Command, _ []string) {
 		log.Fatalf("json error: %s", err)
 	}
 
-	// prep fzf input
-	fzfInput := ""
+	// prep the picker's input
+	input := ""
 	for i, h := range hist {
 		clean := strings.Trim(clipboardSanitize.ReplaceAllString(h, " "), " ")
 		if clean == "" {
 			continue
 		}
 
-		fzfInput += fmt.Sprintf("(%d) %s\n", i, clean)
+		input += fmt.Sprintf("(%d) %s\n", i, clean)
 	}
 
-	// run fzf
-	result, err := runFZF(shellFzfClipboard, &fzfInput)
+	// run the picker
+	result, err := p.pick(input, pickOpts{
+		fzf:    getConfig().Fzf.Clipboard,
+		prompt: "Copy which one to the clipboard?: ",
+		id:     idPrefix,
+		preview: func(row string) string {
+			id, err := matchPrefixID(row)
+			if err != nil || id >= len(hist) {
+				return ""
+			}
+			return hist[id]
+		},
+	})
 	if err != nil {
-		log.Fatalf("fzf error: %s", err)
+		log.Fatalf("picker error: %s", err)
 	}
 	// match the entry's ID at the end of the line
 	id, err := matchPrefixID(result)
@@ -189,33 +486,60 @@ func CmdFzfClipboard(_ *cobra.Command, _ []string) {
 	}
 
 	// set the clipboard
-	_, err = daemon.RemoteCall("Daemon.RemoteCopy", daemon.RPCArgs{
-		Clipboard: hist[id],
-	})
+	err = daemon.RemoteCall("Daemon.RemoteCopy",
+		daemon.CopyArgs{Text: hist[id]}, &daemon.Empty{})
 	if err != nil {
-		log.Fatalf("rpc error: %s", err)
+		fatalRPC(err)
 	}
 }
 
-func CmdFzfPath(_ *cobra.Command, _ []string) {
+func pickPath(p picker) {
 	// req the daemon
-	list, err := daemon.RemoteCall("Daemon.RemoteGetPathFiles", daemon.RPCArgs{})
+	var reply daemon.PathFilesReply
+	err := daemon.RemoteCall("Daemon.RemoteGetPathFiles", daemon.Empty{},
+		&reply)
 	if err != nil {
-		log.Fatalf("rpc error: %s", err)
+		fatalRPC(err)
 	}
+	list := strings.Join(reply.Files, "\n")
 
-	// run fzf
-	result, err := runFZF(shellFzfPath, &list)
+	// run the picker
+	result, err := p.pick(list, pickOpts{
+		fzf:    getConfig().Fzf.Path,
+		prompt: "Run: ",
+	})
 	if err != nil {
-		log.Fatalf("fzf error: %s", err)
+		log.Fatalf("picker error: %s", err)
 	}
 
 	// return the picked exe
 	log.Printf("path: %s", result)
-	result, err = daemon.RemoteCall("Daemon.RemoteExec", daemon.RPCArgs{
-		ExePath: result,
-	})
+	err = daemon.RemoteCall("Daemon.RemoteExec",
+		daemon.ExecArgs{Path: result}, &daemon.Empty{})
 	if err != nil {
-		log.Fatalf("error: cant run %s", result)
+		fatalRPC(err)
 	}
 }
+
+// cfg is the daemon's config, see getConfig
+var cfg *config.Config
+
+// getConfig returns the config of the running daemon, or the defaults.
+func getConfig() *config.Config {
+	if cfg != nil {
+		return cfg
+	}
+
+	var reply daemon.ConfigReply
+	err := daemon.RemoteCall("Daemon.RemoteGetConfig", daemon.ConfigArgs{},
+		&reply)
+	if err == nil {
+		cfg, err = config.Parse([]byte(reply.YAML))
+	}
+	if err != nil {
+		log.Printf("config error: %s", err)
+		cfg = config.Default()
+	}
+
+	return cfg
+}
@@ -0,0 +1,510 @@
+package cmds
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pancsta/sway-yasm/internal/config"
+	"github.com/pancsta/sway-yasm/internal/daemon"
+)
+
+// picker shows the rows and returns the picked one, as passed in, including
+// the ID.
+type picker interface {
+	pick(input string, opts pickOpts) (string, error)
+}
+
+type pickOpts struct {
+	// fzf is the fzf shell snippet, see config.Fzf
+	fzf string
+	// prompt of the dmenu backends
+	prompt string
+	// pos is the 1-based row to preselect, 0 for the default
+	pos int
+	// id matches the row's ID, which gets hidden by the backends returning
+	// the row's index
+	id *regexp.Regexp
+	// multi allows picking several rows, one per line, where supported
+	multi bool
+	// preview returns the details of a row, where supported
+	preview func(row string) string
+	// actions are daemon.PickerCycle and daemon.PickerClose, see
+	// sessionPicker
+	actions <-chan string
+}
+
+var (
+	idSuffix = regexp.MustCompile(`\s*\(\d+\)\s*$`)
+	idPrefix = regexp.MustCompile(`^\s*\(\d+\)\s*`)
+)
+
+// pickerFlag adds --picker to a command showing a picker.
+func pickerFlag(cmd *cobra.Command) {
+	cmd.Flags().String("picker", "", "Picker backend, one of: "+
+		strings.Join(config.PickerBackends, ", ")+" (default picker.backend)")
+}
+
+// pickerName returns --picker or picker.backend.
+func pickerName(cmd *cobra.Command) string {
+	name, _ := cmd.Flags().GetString("picker")
+	if name == "" {
+		name = getConfig().Picker.Backend
+	}
+
+	return name
+}
+
+// newPicker returns the picker backend, and true if it runs in a terminal.
+// fzf falls back to the builtin picker, when not installed.
+func newPicker(name string) (picker, bool) {
+	switch name {
+	case "fzf":
+		if _, err := exec.LookPath("fzf"); err != nil {
+			return tuiPicker{}, true
+		}
+		return fzfPicker{}, true
+	case "builtin":
+		return tuiPicker{}, true
+	}
+
+	p, ok := dmenuPickers[name]
+	if !ok {
+		log.Fatalf("error: unknown picker %s, expected one of: %s", name,
+			strings.Join(config.PickerBackends, ", "))
+	}
+
+	return p, false
+}
+
+// ///// ///// /////
+// ///// SESSION
+// ///// ///// /////
+
+// sessionPicker attaches a picker to its session in the daemon, which cycles
+// or closes it when re-invoked, see Daemon.RemotePickerShow.
+type sessionPicker struct {
+	picker
+	id int
+}
+
+func (p sessionPicker) pick(input string, opts pickOpts) (string, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	actions := make(chan string, 16)
+	go watchSession(ctx, p.id, actions)
+
+	var reply daemon.PickerAttachReply
+	err := daemon.RemoteCall("Daemon.RemotePickerAttach",
+		daemon.PickerAttachArgs{Session: p.id, PID: os.Getpid()}, &reply)
+	if err != nil {
+		return "", err
+	}
+	// re-invoked while starting
+	for i := 0; i < reply.Cycles && i < cap(actions); i++ {
+		select {
+		case actions <- daemon.PickerCycle:
+		default:
+		}
+	}
+	opts.actions = actions
+
+	return p.picker.pick(input, opts)
+}
+
+// watchSession passes the actions of the session, until ctx is done.
+func watchSession(ctx context.Context, id int, actions chan<- string) {
+	r, w := io.Pipe()
+	// unblock the writer
+	defer r.Close()
+	go func() {
+		err := daemon.Subscribe(ctx, []string{daemon.EventPicker}, w)
+		w.CloseWithError(err)
+	}()
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var e daemon.Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if e.Session != id {
+			continue
+		}
+		select {
+		case actions <- e.Action:
+		default:
+		}
+	}
+}
+
+// ///// ///// /////
+// ///// FZF
+// ///// ///// /////
+
+// fzfPicker runs fzf in the current terminal. The actions are sent to fzf's
+// HTTP server, see --listen, authorized with a random FZF_API_KEY.
+type fzfPicker struct{}
+
+// fzfListenAttempts retries fzf, when another process takes the free port
+// before fzf binds it.
+const fzfListenAttempts = 3
+
+func (fzfPicker) pick(input string, opts pickOpts) (string, error) {
+	shell := opts.fzf
+	if opts.pos > 0 {
+		shell = strings.Replace(shell, "load:pos(2)",
+			fmt.Sprintf("load:pos(%d)", opts.pos), 1)
+	}
+	if opts.multi {
+		shell = fzfFlag(shell, "--multi --bind ctrl-space:toggle")
+	}
+	if opts.preview != nil {
+		// fzf runs the preview as a command, which reads the rendered ones
+		exe, err := os.Executable()
+		if err != nil {
+			return "", err
+		}
+		dir, err := writePreviews(input, opts.preview)
+		if err != nil {
+			return "", err
+		}
+		defer os.RemoveAll(dir)
+		shell = fzfFlag(shell, "--preview "+shellQuote(shellQuote(exe)+
+			" fzf preview "+shellQuote(dir)+" {}"))
+	}
+	if opts.actions == nil {
+		return runFZF(shell, &input)
+	}
+
+	key, err := fzfAPIKey()
+	if err != nil {
+		return "", err
+	}
+	var port atomic.Int32
+	if err := nextPort(&port); err != nil {
+		return "", err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case action := <-opts.actions:
+				fzfAction := "down"
+				if action == daemon.PickerClose {
+					fzfAction = "abort"
+				}
+				fzfPost(int(port.Load()), key, fzfAction)
+			}
+		}
+	}()
+
+	for i := 1; ; i++ {
+		listen := fzfFlag(shell, fmt.Sprintf("--listen=%d", port.Load()))
+
+		ret, err := runFZF(listen, &input, "FZF_API_KEY="+key)
+		// fzf exits with 2 on errors, including a taken port
+		var exitErr *exec.ExitError
+		if i < fzfListenAttempts && errors.As(err, &exitErr) &&
+			exitErr.ExitCode() == 2 {
+
+			log.Printf("fzf error, retrying on another port: %s", err)
+			if err := nextPort(&port); err != nil {
+				return "", err
+			}
+			continue
+		}
+
+		return ret, err
+	}
+}
+
+// fzfFlag appends flags to the fzf shell snippet.
+func fzfFlag(shell, flags string) string {
+	return strings.TrimRight(shell, " \n") + " " + flags + "\n"
+}
+
+// writePreviews renders the preview of each row into a new temp dir, see
+// readPreview.
+func writePreviews(
+	input string, preview func(row string) string,
+) (string, error) {
+	dir, err := os.MkdirTemp("", "sway-yasm-preview-")
+	if err != nil {
+		return "", err
+	}
+	for _, row := range strings.Split(input, "\n") {
+		if row == "" {
+			continue
+		}
+		err := os.WriteFile(filepath.Join(dir, previewKey(row)),
+			[]byte(preview(row)), 0o600)
+		if err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+	}
+
+	return dir, nil
+}
+
+// readPreview returns the preview of the row, as passed by fzf's {}, from
+// the dir of writePreviews.
+func readPreview(dir, row string) string {
+	text, err := os.ReadFile(filepath.Join(dir, previewKey(row)))
+	if err != nil {
+		return ""
+	}
+
+	return string(text)
+}
+
+// previewKey names the preview file of the row, without the ANSI styles
+// stripped by fzf.
+func previewKey(row string) string {
+	hash := fnv.New64a()
+	hash.Write([]byte(strings.TrimSpace(ansiEscape.ReplaceAllString(row, ""))))
+
+	return fmt.Sprintf("%016x", hash.Sum64())
+}
+
+// fzfAPIKey returns a random key for fzf's HTTP server, which otherwise
+// accepts actions from any local user.
+func fzfAPIKey() (string, error) {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(key), nil
+}
+
+// fzfPost sends an action to fzf's HTTP server, retrying while it starts.
+func fzfPost(port int, key, action string) {
+	url := fmt.Sprintf("http://127.0.0.1:%d", port)
+	for i := 0; i < 20; i++ {
+		req, err := http.NewRequest(http.MethodPost, url,
+			strings.NewReader(action))
+		if err != nil {
+			return
+		}
+		req.Header.Set("x-api-key", key)
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// nextPort stores a new free port for fzf.
+func nextPort(port *atomic.Int32) error {
+	p, err := freePort()
+	if err != nil {
+		return err
+	}
+	port.Store(int32(p))
+
+	return nil
+}
+
+// freePort returns a free local TCP port. Another process can take it before
+// fzf does, which makes fzf exit, while the API key keeps the actions private.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// ///// ///// /////
+// ///// DMENU
+// ///// ///// /////
+
+// dmenuPicker runs a launcher in its dmenu mode, without a terminal.
+type dmenuPicker struct {
+	// args returns the command line for the prompt and the 0-based row to
+	// preselect, or -1
+	args func(prompt string, selected int) []string
+	// index is true for launchers printing the picked row's index, instead
+	// of its text
+	index bool
+	// preselect is false for launchers always starting at the first row, in
+	// which case the preselected row gets moved to the top
+	preselect bool
+	// theme returns the config of the launcher
+	theme func(cfg *config.Picker) config.Dmenu
+}
+
+var dmenuPickers = map[string]*dmenuPicker{
+	"fuzzel": {
+		args: func(prompt string, selected int) []string {
+			args := []string{"fuzzel", "--dmenu", "--index", "--prompt", prompt}
+			if selected >= 0 {
+				args = append(args, "--select-index", strconv.Itoa(selected))
+			}
+			return args
+		},
+		index:     true,
+		preselect: true,
+		theme:     func(cfg *config.Picker) config.Dmenu { return cfg.Fuzzel },
+	},
+	"wofi": {
+		args: func(prompt string, _ int) []string {
+			// the cache would reorder the rows by usage
+			return []string{"wofi", "--dmenu", "--insensitive", "--prompt",
+				prompt, "--cache-file", "/dev/null"}
+		},
+		theme: func(cfg *config.Picker) config.Dmenu { return cfg.Wofi },
+	},
+	"rofi": {
+		args: func(prompt string, selected int) []string {
+			args := []string{"rofi", "-dmenu", "-i", "-no-custom", "-format",
+				"i", "-p", strings.TrimRight(prompt, ": ")}
+			if selected >= 0 {
+				args = append(args, "-selected-row", strconv.Itoa(selected))
+			}
+			return args
+		},
+		index:     true,
+		preselect: true,
+		theme:     func(cfg *config.Picker) config.Dmenu { return cfg.Rofi },
+	},
+	"bemenu": {
+		args: func(prompt string, _ int) []string {
+			return []string{"bemenu", "-i", "-p", strings.TrimRight(prompt, " ")}
+		},
+		theme: func(cfg *config.Picker) config.Dmenu { return cfg.Bemenu },
+	},
+}
+
+func (p *dmenuPicker) pick(input string, opts pickOpts) (string, error) {
+	rows := strings.Split(strings.TrimRight(input, "\n"), "\n")
+
+	selected := opts.pos - 1
+	if selected >= len(rows) {
+		selected = -1
+	}
+	// emulate the preselection by moving the row to the top
+	if !p.preselect && selected > 0 {
+		row := rows[selected]
+		rows = append(rows[:selected], rows[selected+1:]...)
+		rows = append([]string{row}, rows...)
+		selected = -1
+	}
+
+	// no ANSI styles, no IDs for the index backends
+	display := make([]string, len(rows))
+	for i, row := range rows {
+		row = ansiEscape.ReplaceAllString(row, "")
+		if p.index && opts.id != nil {
+			row = opts.id.ReplaceAllString(row, "")
+		}
+		display[i] = strings.TrimRight(row, " ")
+	}
+
+	// build the shell command with the theme
+	theme := p.theme(&getConfig().Picker)
+	command := func(selected int) string {
+		args := p.args(opts.prompt, selected)
+		for i, arg := range args {
+			args[i] = shellQuote(arg)
+		}
+		shell := strings.Join(args, " ") + " " + strings.TrimSpace(theme.Args)
+		if daemon.IsLightMode() {
+			shell += " " + strings.TrimSpace(theme.Light)
+		}
+		return shell
+	}
+
+	// rows without an ID, eg the group headers, can't be skipped by the
+	// launchers, so the picker shows again
+	for {
+		out, err := runPicker(command(selected),
+			strings.Join(display, "\n")+"\n", opts.actions)
+		if err != nil {
+			return "", err
+		}
+		out = strings.TrimRight(out, "\n")
+		row, i := out, -1
+		if p.index {
+			i, err = strconv.Atoi(strings.TrimSpace(out))
+			if err != nil || i < 0 || i >= len(rows) {
+				return "", fmt.Errorf("invalid index %q", out)
+			}
+			row = rows[i]
+		}
+		if opts.id == nil || opts.id.MatchString(row) {
+			return row, nil
+		}
+
+		log.Printf("picked %q without an ID, showing again", row)
+		// preselect the row below
+		selected = -1
+		if p.preselect && i >= 0 && i+1 < len(rows) {
+			selected = i + 1
+		}
+	}
+}
+
+// runPicker runs the launcher, which gets killed by daemon.PickerClose.
+func runPicker(cmd, input string, actions <-chan string) (string, error) {
+	shell := os.Getenv("SHELL")
+	if len(shell) == 0 {
+		shell = "sh"
+	}
+
+	var out bytes.Buffer
+	picker := exec.Command(shell, "-c", cmd)
+	picker.Stdin = bytes.NewBufferString(input)
+	picker.Stdout = &out
+	picker.Stderr = os.Stderr
+	if err := picker.Start(); err != nil {
+		return "", err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case action := <-actions:
+				if action == daemon.PickerClose {
+					picker.Process.Kill()
+				}
+			}
+		}
+	}()
+	err := picker.Wait()
+
+	return out.String(), err
+}
@@ -0,0 +1,214 @@
+package cmds
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pancsta/sway-yasm/internal/config"
+)
+
+func TestFzfPost(t *testing.T) {
+	key, err := fzfAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other, _ := fzfAPIKey(); len(key) != 32 || other == key {
+		t.Fatalf("keys %s, %s", key, other)
+	}
+
+	actions := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("x-api-key") != key {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			body, _ := io.ReadAll(r.Body)
+			actions <- string(body)
+		}))
+	defer srv.Close()
+
+	port := srv.Listener.Addr().(*net.TCPAddr).Port
+	fzfPost(port, key, "down")
+	if action := <-actions; action != "down" {
+		t.Errorf("action %q, want down", action)
+	}
+}
+
+func TestDmenuHeaders(t *testing.T) {
+	cfg = config.Default()
+	input := "-- DP-1 --\nfoot | shell (11)\n-- HDMI-A-1 --\ncode | yasm (22)\n"
+
+	tests := []struct {
+		name string
+		// index backends print the row's index
+		index bool
+		// picks are printed by the launcher, one per run
+		picks []string
+		want  string
+		// selected are the preselected rows of each run
+		selected []string
+	}{
+		{"index", true, []string{"0", "1"}, "foot | shell (11)",
+			[]string{"-1", "1"}},
+		{"index twice", true, []string{"2", "0", "3"}, "code | yasm (22)",
+			[]string{"-1", "3", "1"}},
+		{"text", false, []string{"-- DP-1 --", "code | yasm (22)"},
+			"code | yasm (22)", []string{"-1", "-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for i, pick := range tt.picks {
+				err := os.WriteFile(filepath.Join(dir, strconv.Itoa(i)),
+					[]byte(pick+"\n"), 0o644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			// prints the next pick and logs the preselected row
+			script := `n=$(($(wc -l < "$1/log"))); echo "$2" >> "$1/log"; ` +
+				`cat "$1/$n"`
+
+			p := &dmenuPicker{
+				args: func(_ string, selected int) []string {
+					return []string{"sh", "-c", script, "sh", dir,
+						strconv.Itoa(selected)}
+				},
+				index:     tt.index,
+				preselect: true,
+				theme: func(*config.Picker) config.Dmenu {
+					return config.Dmenu{}
+				},
+			}
+			if err := os.WriteFile(filepath.Join(dir, "log"), nil,
+				0o644); err != nil {
+
+				t.Fatal(err)
+			}
+
+			got, err := p.pick(input, pickOpts{id: idSuffix})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("picked %q, want %q", got, tt.want)
+			}
+			log, _ := os.ReadFile(filepath.Join(dir, "log"))
+			selected := strings.Fields(string(log))
+			if !slices.Equal(selected, tt.selected) {
+				t.Errorf("selected %v, want %v", selected, tt.selected)
+			}
+		})
+	}
+}
+
+// fakePicker returns the rows in order, recording the preselected positions.
+type fakePicker struct {
+	rows []string
+	pos  []int
+}
+
+func (p *fakePicker) pick(_ string, opts pickOpts) (string, error) {
+	p.pos = append(p.pos, opts.pos)
+	row := p.rows[0]
+	p.rows = p.rows[1:]
+	return row, nil
+}
+
+func TestPickSuffixID(t *testing.T) {
+	input := "-- DP-1 --\n" + ansiBold + "foot (13)" + ansiReset + "\n" +
+		"-- HDMI-A-1 --\ncode (22)  \n"
+	tests := []struct {
+		name string
+		rows []string
+		want int
+		pos  []int
+	}{
+		{"window", []string{"code (22)"}, 22, []int{2}},
+		{"header", []string{"-- HDMI-A-1 --", "code (22)"}, 22, []int{2, 4}},
+		{"headers", []string{"-- DP-1 --", "-- HDMI-A-1 --", "foot (13)"},
+			13, []int{2, 2, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &fakePicker{rows: tt.rows}
+			id, err := pickSuffixID(p, input, pickOpts{pos: 2, id: idSuffix})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != tt.want {
+				t.Errorf("id %d, want %d", id, tt.want)
+			}
+			if !slices.Equal(p.pos, tt.pos) {
+				t.Errorf("pos %v, want %v", p.pos, tt.pos)
+			}
+		})
+	}
+
+	// an unknown row
+	p := &fakePicker{rows: []string{"foo"}}
+	if _, err := pickSuffixID(p, input, pickOpts{}); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestFzfPreview(t *testing.T) {
+	cfg = config.Default()
+	input := ansiBold + "foot | shell (11)" + ansiReset + "  \n" +
+		"code | yasm (22)\n"
+	preview := func(row string) string {
+		id, _ := matchSuffixID(row)
+		return "window " + strconv.Itoa(id)
+	}
+
+	dir, err := writePreviews(input, preview)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// as passed by fzf
+	for _, row := range []string{"foot | shell (11)  ", "code | yasm (22)"} {
+		if got, want := readPreview(dir, row), preview(row); got != want {
+			t.Errorf("preview %q, want %q", got, want)
+		}
+	}
+	if got := readPreview(dir, "foo"); got != "" {
+		t.Errorf("preview %q, want none", got)
+	}
+
+	// a fake fzf printing its flags
+	bin := t.TempDir()
+	err = os.WriteFile(filepath.Join(bin, "fzf"),
+		[]byte("#!/bin/sh\nprintf '%s\\n' \"$@\"\n"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("SHELL", "sh")
+
+	out, err := fzfPicker{}.pick(input, pickOpts{fzf: "fzf --ansi",
+		multi: true, preview: preview})
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags := strings.Split(strings.TrimRight(out, "\n"), "\n")
+	if !slices.Contains(flags, "--multi") {
+		t.Errorf("flags %q, want --multi", flags)
+	}
+	i := slices.Index(flags, "--preview")
+	if i < 0 || i+1 == len(flags) ||
+		!strings.Contains(flags[i+1], " fzf preview ") ||
+		!strings.HasSuffix(flags[i+1], " {}") {
+
+		t.Fatalf("flags %q, want --preview", flags)
+	}
+}
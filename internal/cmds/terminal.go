@@ -0,0 +1,173 @@
+package cmds
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/pancsta/sway-yasm/internal/config"
+)
+
+// terminal is a built-in terminal backend. The window gets config.WindowID as
+// the title and app_id, where supported, for the autoconfig rules.
+type terminal struct {
+	// bin is looked up in PATH by the auto detection
+	bin string
+	// ready optionally checks more than bin, eg a running server
+	ready func() bool
+	// args returns the whole command line running cmd
+	args func(cmd []string) []string
+}
+
+var terminals = map[string]terminal{
+	"foot": {
+		bin: "foot",
+		args: func(cmd []string) []string {
+			return append([]string{"foot", "--app-id", config.WindowID,
+				"--title", config.WindowID}, cmd...)
+		},
+	},
+	"footclient": {
+		bin:   "footclient",
+		ready: footServerRunning,
+		args: func(cmd []string) []string {
+			return append([]string{"footclient", "--app-id", config.WindowID,
+				"--title", config.WindowID}, cmd...)
+		},
+	},
+	"alacritty": {
+		bin: "alacritty",
+		args: func(cmd []string) []string {
+			// --class sets the app_id on wayland
+			return append([]string{"alacritty", "--class", config.WindowID,
+				"--title", config.WindowID, "-e"}, cmd...)
+		},
+	},
+	"kitty": {
+		bin: "kitty",
+		args: func(cmd []string) []string {
+			return append([]string{"kitty", "--class", config.WindowID,
+				"--title", config.WindowID}, cmd...)
+		},
+	},
+	"wezterm": {
+		bin: "wezterm",
+		args: func(cmd []string) []string {
+			// no title flag, the app_id is enough
+			return append([]string{"wezterm", "start", "--class",
+				config.WindowID, "--"}, cmd...)
+		},
+	},
+}
+
+// terminalAuto is the detection order of the "auto" backend. footclient goes
+// first, as it's only picked with a running server.
+var terminalAuto = []string{"footclient", "foot", "alacritty", "kitty",
+	"wezterm"}
+
+// runTerminal runs "sway-yasm fzf" with the args in the configured terminal
+// and waits for it to close.
+func runTerminal(args ...string) error {
+	cfg := getConfig().Terminal
+	cmd := append([]string{"sway-yasm", "fzf"}, args...)
+
+	backend := cfg.Backend
+	if backend == "auto" {
+		var err error
+		backend, err = detectTerminal()
+		if err != nil {
+			return err
+		}
+	}
+
+	if backend == "generic" {
+		shell, err := genericTerminal(cfg.Command, cmd)
+		if err != nil {
+			return err
+		}
+		_, err = run(shell)
+		return err
+	}
+
+	term, ok := terminals[backend]
+	if !ok {
+		return fmt.Errorf("unknown terminal backend %s", backend)
+	}
+	termArgs := term.args(cmd)
+
+	return exec.Command(termArgs[0], termArgs[1:]...).Run()
+}
+
+// detectTerminal returns the first installed backend of terminalAuto.
+func detectTerminal() (string, error) {
+	for _, name := range terminalAuto {
+		term := terminals[name]
+		if _, err := exec.LookPath(term.bin); err != nil {
+			continue
+		}
+		if term.ready != nil && !term.ready() {
+			continue
+		}
+
+		return name, nil
+	}
+
+	return "", fmt.Errorf("no supported terminal found (%s), set "+
+		"terminal.backend to generic", strings.Join(terminalAuto, ", "))
+}
+
+// genericTerminal renders the terminal.command template into a shell command.
+func genericTerminal(tpl string, cmd []string) (string, error) {
+	t, err := template.New("").Parse(tpl)
+	if err != nil {
+		return "", fmt.Errorf("terminal.command: %w", err)
+	}
+
+	quoted := make([]string, len(cmd))
+	for i, arg := range cmd {
+		quoted[i] = shellQuote(arg)
+	}
+
+	var buf bytes.Buffer
+	err = t.Execute(&buf, struct {
+		AppID string
+		Title string
+		Cmd   string
+	}{
+		AppID: config.WindowID,
+		Title: config.WindowID,
+		Cmd:   strings.Join(quoted, " "),
+	})
+	if err != nil {
+		return "", fmt.Errorf("terminal.command: %w", err)
+	}
+
+	return buf.String(), nil
+}
+
+// footServerRunning checks for the socket of "foot --server".
+func footServerRunning() bool {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		return false
+	}
+	socks := []string{
+		filepath.Join(dir, "foot-"+os.Getenv("WAYLAND_DISPLAY")+".sock"),
+		filepath.Join(dir, "foot.sock"),
+	}
+	for _, sock := range socks {
+		if _, err := os.Stat(sock); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,487 @@
+package cmds
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"regexp"
+	"slices"
+	"strings"
+	"syscall"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+
+	"github.com/pancsta/sway-yasm/internal/daemon"
+)
+
+const (
+	ansiReverse   = "\x1b[7m"
+	ansiHighlight = "\x1b[1;32m"
+)
+
+var (
+	errAborted = errors.New("aborted")
+	// ansiPrefix is the leading style of a row
+	ansiPrefix = regexp.MustCompile(`^(\x1b\[[0-9;]*m)+`)
+)
+
+// tuiPicker is the built-in terminal picker, used when fzf isn't installed.
+// It reads the keys from /dev/tty, like fzf.
+type tuiPicker struct{}
+
+func (tuiPicker) pick(input string, opts pickOpts) (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", err
+	}
+	defer tty.Close()
+
+	rows := strings.Split(strings.TrimRight(input, "\n"), "\n")
+	picked, err := runTUI(tty, rows, opts)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join(picked, "\n"), nil
+}
+
+// runTUI shows the picker on the terminal and returns the picked rows, as
+// passed in. Keys:
+//   - tab, down, ctrl+n: next row
+//   - shift+tab, up, ctrl+p: previous row
+//   - enter, space: accept
+//   - ctrl+space: mark the row (opts.multi)
+//   - ctrl+u, ctrl+w: delete the query, or its last word
+//   - esc, ctrl+c, ctrl+g: abort
+func runTUI(tty *os.File, rows []string, opts pickOpts) ([]string, error) {
+	fd := int(tty.Fd())
+	prev, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, err
+	}
+	defer term.Restore(fd, prev)
+
+	// alternate screen
+	fmt.Fprint(tty, "\x1b[?1049h")
+	defer fmt.Fprint(tty, "\x1b[?1049l")
+
+	t := newTUI(rows, opts)
+	t.resize(fd)
+
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+
+	keys := make(chan []byte)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(keys)
+		for {
+			buf := make([]byte, 256)
+			n, err := tty.Read(buf)
+			if err != nil {
+				return
+			}
+			select {
+			case keys <- buf[:n]:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		fmt.Fprint(tty, t.render())
+
+		select {
+		case <-winch:
+			t.resize(fd)
+
+		case action := <-opts.actions:
+			if action == daemon.PickerClose {
+				return nil, errAborted
+			}
+			t.move(1)
+
+		case buf, ok := <-keys:
+			if !ok {
+				return nil, errAborted
+			}
+			picked, err := t.input(buf)
+			if err != nil || picked != nil {
+				return picked, err
+			}
+		}
+	}
+}
+
+// tui is the state of runTUI.
+type tui struct {
+	opts pickOpts
+	rows []string
+	// plain are the rows without ANSI styles, for matching
+	plain []string
+	query []rune
+	// matches are ranked
+	matches []tuiMatch
+	// cursor is the index of the selected match
+	cursor int
+	// offset is the first visible match
+	offset int
+	// marked are the marked row indexes
+	marked        map[int]bool
+	width, height int
+}
+
+type tuiMatch struct {
+	row   int
+	score int
+	// pos are the matched rune positions of the plain row
+	pos []int
+}
+
+func newTUI(rows []string, opts pickOpts) *tui {
+	t := &tui{
+		opts:   opts,
+		rows:   rows,
+		marked: map[int]bool{},
+		width:  80,
+		height: 24,
+	}
+	for _, row := range rows {
+		t.plain = append(t.plain, ansiEscape.ReplaceAllString(row, ""))
+	}
+	t.filter()
+
+	// preselect the row, as fzf's load:pos()
+	if opts.pos > 0 && opts.pos <= len(t.matches) {
+		t.cursor = opts.pos - 1
+	}
+
+	return t
+}
+
+func (t *tui) resize(fd int) {
+	width, height, err := term.GetSize(fd)
+	if err == nil && width > 0 && height > 1 {
+		t.width, t.height = width, height
+	}
+}
+
+// input handles a chunk of keys. Returns the picked rows when accepted.
+func (t *tui) input(buf []byte) ([]string, error) {
+	// a lone escape, not a sequence
+	if len(buf) == 1 && buf[0] == 0x1b {
+		return nil, errAborted
+	}
+
+	for len(buf) > 0 {
+		if buf[0] == 0x1b {
+			seq := escapeSeq(buf)
+			buf = buf[len(seq):]
+			switch seq {
+			case "\x1b[A", "\x1bOA", "\x1b[Z":
+				t.move(-1)
+			case "\x1b[B", "\x1bOB":
+				t.move(1)
+			case "\x1b[5~":
+				t.move(-(t.height - 1))
+			case "\x1b[6~":
+				t.move(t.height - 1)
+			}
+			continue
+		}
+
+		r, size := utf8.DecodeRune(buf)
+		buf = buf[size:]
+		switch r {
+		case 0x03, 0x07:
+			return nil, errAborted
+		case '\r', '\n', ' ':
+			if picked := t.picked(); picked != nil {
+				return picked, nil
+			}
+		case '\t', 0x0e:
+			t.move(1)
+		case 0x10:
+			t.move(-1)
+		case 0x00:
+			t.mark()
+		case 0x7f, 0x08:
+			if len(t.query) > 0 {
+				t.setQuery(t.query[:len(t.query)-1])
+			}
+		case 0x15:
+			t.setQuery(nil)
+		case 0x17:
+			q := strings.TrimRight(string(t.query), " ")
+			q = q[:strings.LastIndex(q, " ")+1]
+			t.setQuery([]rune(q))
+		default:
+			if unicode.IsPrint(r) {
+				t.setQuery(append(t.query, r))
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// escapeSeq returns the escape sequence at the start of buf.
+func escapeSeq(buf []byte) string {
+	if len(buf) < 2 || (buf[1] != '[' && buf[1] != 'O') {
+		return string(buf[:1])
+	}
+	for i := 2; i < len(buf); i++ {
+		// the final byte
+		if buf[i] >= 0x40 && buf[i] <= 0x7e {
+			return string(buf[:i+1])
+		}
+	}
+
+	return string(buf)
+}
+
+func (t *tui) setQuery(q []rune) {
+	t.query = q
+	t.filter()
+	// as fzf's change:pos(1)
+	t.cursor = 0
+	t.offset = 0
+}
+
+// move moves the cursor by n, wrapping single steps around.
+func (t *tui) move(n int) {
+	if len(t.matches) == 0 {
+		return
+	}
+	t.cursor += n
+	switch {
+	case t.cursor < 0 && n == -1:
+		t.cursor = len(t.matches) - 1
+	case t.cursor >= len(t.matches) && n == 1:
+		t.cursor = 0
+	}
+	t.cursor = max(0, min(t.cursor, len(t.matches)-1))
+}
+
+func (t *tui) mark() {
+	if !t.opts.multi || len(t.matches) == 0 {
+		return
+	}
+	row := t.matches[t.cursor].row
+	if t.marked[row] {
+		delete(t.marked, row)
+	} else {
+		t.marked[row] = true
+	}
+	t.move(1)
+}
+
+// picked returns the marked rows, or the selected one.
+func (t *tui) picked() []string {
+	if len(t.marked) > 0 {
+		var ret []string
+		for i, row := range t.rows {
+			if t.marked[i] {
+				ret = append(ret, row)
+			}
+		}
+		return ret
+	}
+	if len(t.matches) == 0 {
+		return nil
+	}
+
+	return []string{t.rows[t.matches[t.cursor].row]}
+}
+
+// ///// ///// /////
+// ///// MATCHING
+// ///// ///// /////
+
+// filter matches the rows against the query. The rows are in the MRU order,
+// so the top ones get a bonus and equal scores keep the order.
+func (t *tui) filter() {
+	t.matches = nil
+	for i, row := range t.plain {
+		if len(t.query) == 0 {
+			t.matches = append(t.matches, tuiMatch{row: i})
+			continue
+		}
+		score, pos, ok := fuzzyMatch([]rune(row), t.query)
+		if !ok {
+			continue
+		}
+		bonus := max(0, 3-i/3)
+		t.matches = append(t.matches, tuiMatch{row: i, score: score + bonus,
+			pos: pos})
+	}
+
+	slices.SortStableFunc(t.matches, func(a, b tuiMatch) int {
+		return b.score - a.score
+	})
+}
+
+// fuzzyMatch matches the query's runes in order, case-insensitive unless the
+// query has upper case. Consecutive runes and word starts score more, gaps
+// less. Returns the best score of all the starting positions.
+func fuzzyMatch(text, query []rune) (int, []int, bool) {
+	fold := !slices.ContainsFunc(query, unicode.IsUpper)
+	eq := func(a, b rune) bool {
+		if fold {
+			return unicode.ToLower(a) == b
+		}
+		return a == b
+	}
+
+	bestScore := 0
+	var bestPos []int
+	for start := range text {
+		if !eq(text[start], query[0]) {
+			continue
+		}
+
+		score := 0
+		var pos []int
+		qi := 0
+		for i := start; i < len(text) && qi < len(query); i++ {
+			if !eq(text[i], query[qi]) {
+				continue
+			}
+			score++
+			if len(pos) > 0 {
+				if gap := i - pos[len(pos)-1] - 1; gap == 0 {
+					score += 4
+				} else {
+					score -= min(gap, 3)
+				}
+			}
+			if i == 0 || !unicode.IsLetter(text[i-1]) &&
+				!unicode.IsDigit(text[i-1]) {
+
+				score += 3
+			}
+			pos = append(pos, i)
+			qi++
+		}
+		if qi < len(query) {
+			// no later start can match either
+			break
+		}
+		if bestPos == nil || score > bestScore {
+			bestScore, bestPos = score, pos
+		}
+	}
+
+	return bestScore, bestPos, bestPos != nil
+}
+
+// ///// ///// /////
+// ///// RENDERING
+// ///// ///// /////
+
+// render returns the whole frame, with the prompt on top.
+func (t *tui) render() string {
+	var b strings.Builder
+	b.WriteString("\x1b[?25l\x1b[H")
+
+	// prompt with the counter
+	prompt := t.opts.prompt + string(t.query)
+	counter := fmt.Sprintf(" %d/%d", len(t.matches), len(t.rows))
+	if len(t.marked) > 0 {
+		counter += fmt.Sprintf(" (%d)", len(t.marked))
+	}
+	prompt = truncate(t.width-displayWidth(counter), prompt)
+	b.WriteString(prompt)
+	b.WriteString(strings.Repeat(" ",
+		max(0, t.width-displayWidth(prompt)-displayWidth(counter))))
+	b.WriteString(counter + "\r\n")
+
+	// list and preview columns
+	listWidth := t.width
+	var preview []string
+	if t.opts.preview != nil && t.width >= 60 {
+		listWidth = t.width / 2
+		if len(t.matches) > 0 {
+			text := t.opts.preview(t.rows[t.matches[t.cursor].row])
+			preview = strings.Split(ansiEscape.ReplaceAllString(text, ""), "\n")
+		}
+	}
+
+	visible := t.height - 1
+	if t.cursor < t.offset {
+		t.offset = t.cursor
+	} else if t.cursor >= t.offset+visible {
+		t.offset = t.cursor - visible + 1
+	}
+
+	for line := 0; line < visible; line++ {
+		i := t.offset + line
+		if i < len(t.matches) {
+			b.WriteString(t.renderRow(t.matches[i], i == t.cursor,
+				listWidth))
+		} else {
+			b.WriteString(strings.Repeat(" ", listWidth))
+		}
+		if listWidth < t.width {
+			b.WriteString(" │ ")
+			if line < len(preview) {
+				b.WriteString(truncate(t.width-listWidth-3,
+					strings.ReplaceAll(preview[line], "\t", " ")))
+			}
+		}
+		b.WriteString("\x1b[K")
+		if line < visible-1 {
+			b.WriteString("\r\n")
+		}
+	}
+
+	// show the cursor after the query
+	b.WriteString(fmt.Sprintf("\x1b[1;%dH\x1b[?25h", displayWidth(prompt)+1))
+
+	return b.String()
+}
+
+// renderRow returns the row padded to the width, with the matched runes
+// highlighted.
+func (t *tui) renderRow(m tuiMatch, selected bool, width int) string {
+	style := ansiPrefix.FindString(t.rows[m.row])
+	if selected {
+		style += ansiReverse
+	}
+
+	prefix := "  "
+	switch {
+	case selected && t.marked[m.row]:
+		prefix = ">*"
+	case selected:
+		prefix = "> "
+	case t.marked[m.row]:
+		prefix = " *"
+	}
+
+	var b strings.Builder
+	b.WriteString(prefix + style)
+	w := displayWidth(prefix)
+	for i, r := range []rune(t.plain[m.row]) {
+		rw := runeWidth(r)
+		if w+rw > width {
+			break
+		}
+		w += rw
+		if slices.Contains(m.pos, i) {
+			b.WriteString(ansiHighlight + string(r) + ansiReset + style)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	b.WriteString(strings.Repeat(" ", max(0, width-w)))
+	b.WriteString(ansiReset)
+
+	return b.String()
+}
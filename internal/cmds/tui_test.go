@@ -0,0 +1,161 @@
+package cmds
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/pancsta/sway-yasm/internal/daemon"
+)
+
+func TestFuzzyMatch(t *testing.T) {
+	tests := []struct {
+		text  string
+		query string
+		ok    bool
+		score int
+		pos   []int
+	}{
+		{"foot", "f", true, 4, []int{0}},
+		{"foot", "ft", true, 3, []int{0, 3}},
+		{"abc", "ab", true, 9, []int{0, 1}},
+		// word starts
+		{"Foo Bar", "fb", true, 5, []int{0, 4}},
+		// the best start wins
+		{"xab ab", "ab", true, 9, []int{4, 5}},
+		// rune positions
+		{"żółw", "łw", true, 6, []int{2, 3}},
+		// smart case
+		{"Foot", "foo", true, 14, []int{0, 1, 2}},
+		{"foot", "Foo", false, 0, nil},
+		{"foot", "fx", false, 0, nil},
+		{"foot", "toof", false, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text+"/"+tt.query, func(t *testing.T) {
+			score, pos, ok := fuzzyMatch([]rune(tt.text), []rune(tt.query))
+			if ok != tt.ok {
+				t.Fatalf("ok %v, want %v", ok, tt.ok)
+			}
+			if score != tt.score {
+				t.Errorf("score %d, want %d", score, tt.score)
+			}
+			if !slices.Equal(pos, tt.pos) {
+				t.Errorf("pos %v, want %v", pos, tt.pos)
+			}
+		})
+	}
+}
+
+func TestRunTUI(t *testing.T) {
+	rows := []string{
+		"firefox | github",
+		"foot | shell",
+		"code | sway-yasm",
+		"foot | logs",
+	}
+
+	tests := []struct {
+		name  string
+		opts  pickOpts
+		keys  []string
+		want  []string
+		abort bool
+	}{
+		{"accept", pickOpts{}, []string{"\r"}, rows[:1], false},
+		{"preselected", pickOpts{pos: 2}, []string{" "}, rows[1:2], false},
+		{"query", pickOpts{}, []string{"code", "\r"}, rows[2:3], false},
+		// equal scores keep the MRU order
+		{"query and tab", pickOpts{}, []string{"foot", "\t", "\r"},
+			rows[3:4], false},
+		{"shift+tab wraps", pickOpts{}, []string{"\x1b[Z", "\r"}, rows[3:4],
+			false},
+		{"arrows", pickOpts{}, []string{"\x1b[B", "\x1b[B", "\x1b[A", "\r"},
+			rows[1:2], false},
+		{"delete the query", pickOpts{}, []string{"xyz", "\x15", "\r"},
+			rows[:1], false},
+		{"delete a word", pickOpts{}, []string{"sway gh", "\x17", "\r"},
+			rows[2:3], false},
+		{"no match", pickOpts{}, []string{"xyz", "\r", "\x03"}, nil, true},
+		{"multi", pickOpts{multi: true}, []string{"\x00", "\t", "\x00", "\r"},
+			[]string{rows[0], rows[2]}, false},
+		{"esc", pickOpts{}, []string{"\x1b"}, nil, true},
+		{"ctrl+c", pickOpts{}, []string{"foo", "\x03"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			picked, err := pickInPty(t, rows, tt.opts, tt.keys)
+			if tt.abort {
+				if !errors.Is(err, errAborted) {
+					t.Errorf("err %v, want aborted", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(picked, tt.want) {
+				t.Errorf("picked %q, want %q", picked, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunTUIActions(t *testing.T) {
+	rows := []string{"a", "b", "c"}
+	actions := make(chan string, 2)
+	opts := pickOpts{actions: actions}
+
+	// re-invoked twice
+	actions <- daemon.PickerCycle
+	actions <- daemon.PickerCycle
+	picked, err := pickInPty(t, rows, opts, []string{"\r"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(picked, []string{"c"}) {
+		t.Errorf("picked %q, want c", picked)
+	}
+
+	actions <- daemon.PickerClose
+	_, err = pickInPty(t, rows, opts, nil)
+	if !errors.Is(err, errAborted) {
+		t.Errorf("err %v, want aborted", err)
+	}
+}
+
+// pickInPty runs the picker in a new pty and types the keys, one read each.
+func pickInPty(
+	t *testing.T, rows []string, opts pickOpts, keys []string,
+) ([]string, error) {
+	t.Helper()
+	ptm, pts := openPty(t)
+
+	type result struct {
+		picked []string
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		picked, err := runTUI(pts, rows, opts)
+		done <- result{picked, err}
+	}()
+
+	for _, key := range keys {
+		// separate reads, so a lone esc isn't a sequence, after the actions
+		time.Sleep(20 * time.Millisecond)
+		if _, err := ptm.WriteString(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case res := <-done:
+		return res.picked, res.err
+	case <-time.After(5 * time.Second):
+		t.Fatal("no pick")
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,306 @@
+package cmds
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pancsta/sway-yasm/internal/daemon"
+)
+
+// waybarOutput is a line of a waybar custom module with "return-type": "json".
+type waybarOutput struct {
+	Text    string `json:"text"`
+	Tooltip string `json:"tooltip"`
+	Class   string `json:"class"`
+}
+
+// waybarModule renders the output from the daemon's state. e is the event
+// which caused the update, or nil for the initial render.
+type waybarModule struct {
+	events []string
+	render func(opts waybarOpts, e *daemon.Event) (waybarOutput, error)
+}
+
+type waybarOpts struct {
+	maxLen    int
+	workspace string
+	// clipboard is the last known clipboard head
+	clipboard string
+}
+
+var waybarModules = map[string]waybarModule{
+	"focused": {
+		events: []string{daemon.EventWindowFocus, daemon.EventWindowTitle,
+			daemon.EventWindowClose, daemon.EventSpaceFocus},
+		render: waybarFocused,
+	},
+	"previous": {
+		events: []string{daemon.EventMRU, daemon.EventWindowTitle},
+		render: waybarPrevious,
+	},
+	"workspaces": {
+		events: []string{daemon.EventMRU, daemon.EventWindowNew,
+			daemon.EventWindowClose, daemon.EventSpaceFocus, daemon.EventOutput},
+		render: waybarWorkspaces,
+	},
+	"mouse-follows-focus": {
+		events: []string{daemon.EventConfig},
+		render: waybarMouse,
+	},
+	"clipboard": {
+		events: []string{daemon.EventClipboard},
+		render: waybarClipboard,
+	},
+}
+
+// waybarModuleNames returns the sorted module names.
+func waybarModuleNames() []string {
+	var names []string
+	for name := range waybarModules {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+func CmdWaybar(cmd *cobra.Command, args []string) {
+	mod, ok := waybarModules[args[0]]
+	if !ok {
+		log.Fatalf("error: unknown module %s, expected one of %s", args[0],
+			strings.Join(waybarModuleNames(), ", "))
+	}
+	opts := waybarOpts{}
+	opts.maxLen, _ = cmd.Flags().GetInt("max-len")
+	opts.workspace, _ = cmd.Flags().GetString("workspace")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt,
+		syscall.SIGTERM)
+	defer stop()
+
+	// print only changes
+	last := ""
+	emit := func(out waybarOutput) {
+		line, err := json.Marshal(out)
+		if err != nil {
+			log.Fatalf("json error: %s", err)
+		}
+		if string(line) != last {
+			fmt.Println(string(line))
+			last = string(line)
+		}
+	}
+
+	// keep the subscription, also between daemon restarts
+	for {
+		err := waybarStream(ctx, mod, &opts, emit)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("waybar error: %s", err)
+		emit(waybarOutput{Tooltip: err.Error(), Class: "disconnected"})
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// waybarStream renders the module on start and after each event, until the
+// subscription fails.
+func waybarStream(
+	ctx context.Context, mod waybarModule, opts *waybarOpts,
+	emit func(waybarOutput),
+) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	r, w := io.Pipe()
+	// unblock the writer
+	defer r.Close()
+	subErr := make(chan error, 1)
+	go func() {
+		err := daemon.Subscribe(ctx, mod.events, w)
+		w.Close()
+		subErr <- err
+	}()
+
+	render := func(e *daemon.Event) error {
+		out, err := mod.render(*opts, e)
+		if err != nil {
+			return err
+		}
+		emit(out)
+		return nil
+	}
+
+	if err := render(nil); err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var e daemon.Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return err
+		}
+		if e.Type == daemon.EventClipboard {
+			opts.clipboard = e.Text
+		}
+		if err := render(&e); err != nil {
+			return err
+		}
+	}
+
+	return <-subErr
+}
+
+// ///// ///// /////
+// ///// MODULES
+// ///// ///// /////
+
+func waybarFocused(opts waybarOpts, _ *daemon.Event) (waybarOutput, error) {
+	var reply daemon.WindowsReply
+	err := daemon.RemoteCall("Daemon.RemoteWindows", daemon.WindowsArgs{},
+		&reply)
+	if err != nil {
+		return waybarOutput{}, err
+	}
+
+	// empty workspace
+	if len(reply.Windows) == 0 || reply.Windows[0].Workspace != reply.Space.Name {
+		return waybarOutput{Class: "empty"}, nil
+	}
+	win := reply.Windows[0]
+
+	return waybarOutput{
+		Text:    pango(truncate(opts.maxLen, win.App+": "+win.Title)),
+		Tooltip: pango(win.Title),
+		Class:   "focused",
+	}, nil
+}
+
+func waybarPrevious(opts waybarOpts, _ *daemon.Event) (waybarOutput, error) {
+	var reply daemon.WindowsReply
+	err := daemon.RemoteCall("Daemon.RemoteWindows", daemon.WindowsArgs{},
+		&reply)
+	if err != nil {
+		return waybarOutput{}, err
+	}
+
+	if len(reply.Windows) < 2 {
+		return waybarOutput{Class: "empty"}, nil
+	}
+	win := reply.Windows[1]
+
+	return waybarOutput{
+		Text:    pango(truncate(opts.maxLen, win.App)),
+		Tooltip: pango(fmt.Sprintf("%s: %s", win.Workspace, win.Title)),
+		Class:   "previous",
+	}, nil
+}
+
+// waybarWorkspaces renders the apps of a single workspace, the focused one by
+// default, with all the workspaces in the tooltip.
+func waybarWorkspaces(opts waybarOpts, _ *daemon.Event) (waybarOutput, error) {
+	var reply daemon.SpacesReply
+	err := daemon.RemoteCall("Daemon.RemoteSpaces", daemon.SpacesArgs{},
+		&reply)
+	if err != nil {
+		return waybarOutput{}, err
+	}
+
+	// the MRU order has the focused workspace first
+	out := waybarOutput{Class: "empty"}
+	var tooltip []string
+	for i, space := range reply.Spaces {
+		apps := strings.Join(space.Apps, ", ")
+		tooltip = append(tooltip, fmt.Sprintf("%s: %s", space.Name, apps))
+
+		match := space.Name == opts.workspace ||
+			(opts.workspace == "" && i == 0)
+		if match && space.Windows > 0 {
+			out.Text = pango(truncate(opts.maxLen, apps))
+			out.Class = "workspace"
+		}
+	}
+	slices.Sort(tooltip)
+	out.Tooltip = pango(strings.Join(tooltip, "\n"))
+
+	return out, nil
+}
+
+func waybarMouse(_ waybarOpts, _ *daemon.Event) (waybarOutput, error) {
+	var reply daemon.ConfigReply
+	err := daemon.RemoteCall("Daemon.RemoteGetConfig",
+		daemon.ConfigArgs{Key: "daemon.mouse_follows_focus"}, &reply)
+	if err != nil {
+		return waybarOutput{}, err
+	}
+
+	if reply.YAML == "true" {
+		return waybarOutput{Text: "on", Tooltip: "Mouse follows focus",
+			Class: "on"}, nil
+	}
+
+	return waybarOutput{Text: "off", Tooltip: "Mouse follows focus",
+		Class: "off"}, nil
+}
+
+// waybarClipboard renders the last copied text, starting with the top of the
+// clipman history.
+func waybarClipboard(opts waybarOpts, e *daemon.Event) (waybarOutput, error) {
+	head := opts.clipboard
+	if e == nil {
+		head = clipmanHead()
+	}
+	if head == "" {
+		return waybarOutput{Class: "empty"}, nil
+	}
+
+	return waybarOutput{
+		Text: pango(truncate(opts.maxLen,
+			clipboardSanitize.ReplaceAllString(head, " "))),
+		Tooltip: pango(head),
+		Class:   "clipboard",
+	}, nil
+}
+
+// ///// ///// /////
+// ///// HELPERS
+// ///// ///// /////
+
+// clipmanHead returns the most recent clipman entry, if any.
+func clipmanHead() string {
+	out, err := exec.Command("clipman", "show-history").Output()
+	if err != nil {
+		return ""
+	}
+	var hist []string
+	if err := json.Unmarshal(out, &hist); err != nil || len(hist) == 0 {
+		return ""
+	}
+
+	return hist[len(hist)-1]
+}
+
+// pango escapes the text for waybar's markup.
+func pango(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").
+		Replace(text)
+}
@@ -0,0 +1,475 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the whole configuration of the daemon and the pickers. Loaded
+// from $XDG_CONFIG_HOME/sway-yasm/config.yaml, with defaults for missing keys.
+type Config struct {
+	Daemon     Daemon     `yaml:"daemon"`
+	Switcher   Switcher   `yaml:"switcher"`
+	Fzf        Fzf        `yaml:"fzf"`
+	Picker     Picker     `yaml:"picker"`
+	Terminal   Terminal   `yaml:"terminal"`
+	Watcher    Watcher    `yaml:"watcher"`
+	Autoconfig Autoconfig `yaml:"autoconfig"`
+}
+
+type Daemon struct {
+	MouseFollowsFocus  bool `yaml:"mouse_follows_focus"`
+	Autoconfig         bool `yaml:"autoconfig"`
+	DefaultKeybindings bool `yaml:"default_keybindings"`
+	MaxTracked         int  `yaml:"max_tracked"`
+	// RPCSocket is a unix socket path, by default one per sway session
+	RPCSocket string `yaml:"rpc_socket"`
+	// max time of an RPC call, for clients
+	RPCTimeout time.Duration `yaml:"rpc_timeout"`
+	// JSONRPC serves JSON-RPC over HTTP on the RPC socket, for non-Go clients
+	JSONRPC bool `yaml:"json_rpc"`
+	// Deprecated: unused since the picker sessions, kept so the existing
+	// files still load
+	PIDTimeout time.Duration `yaml:"pid_timeout,omitempty"`
+	// delays between sway reconnection attempts
+	ReconnectMin time.Duration `yaml:"reconnect_min"`
+	ReconnectMax time.Duration `yaml:"reconnect_max"`
+}
+
+type Switcher struct {
+	// RowFormat is a text/template for window rows
+	RowFormat string `yaml:"row_format"`
+	// column widths of the workspace rows
+	LenSpace   int `yaml:"len_space"`
+	LenDisplay int `yaml:"len_display"`
+	LenApps    int `yaml:"len_apps"`
+}
+
+// Fzf holds shell snippets running fzf, per picker.
+type Fzf struct {
+	Switcher      string `yaml:"switcher"`
+	SpaceSwitcher string `yaml:"space_switcher"`
+	PickWin       string `yaml:"pick_win"`
+	PickSpace     string `yaml:"pick_space"`
+	Clipboard     string `yaml:"clipboard"`
+	Path          string `yaml:"path"`
+	// Light is appended in the light mode
+	Light string `yaml:"light"`
+}
+
+// Picker selects the picker backend, which can be overridden per command with
+// --picker. fzf and builtin run in a terminal, fzf falls back to builtin when
+// not installed.
+type Picker struct {
+	// Backend is one of PickerBackends
+	Backend string `yaml:"backend"`
+	// Warm keeps a fzf or builtin picker terminal in the scratchpad, ready to
+	// be shown without starting a terminal
+	Warm   bool  `yaml:"warm"`
+	Fuzzel Dmenu `yaml:"fuzzel"`
+	Wofi   Dmenu `yaml:"wofi"`
+	Rofi   Dmenu `yaml:"rofi"`
+	Bemenu Dmenu `yaml:"bemenu"`
+}
+
+// Dmenu holds shell snippets with extra args of a dmenu backend, eg a theme.
+type Dmenu struct {
+	Args string `yaml:"args"`
+	// Light is appended in the light mode
+	Light string `yaml:"light"`
+}
+
+// PickerBackends are the valid values of picker.backend.
+var PickerBackends = []string{"fzf", "builtin", "fuzzel", "wofi", "rofi",
+	"bemenu"}
+
+// Terminal opens the pickers in a new window, identified by WindowID.
+type Terminal struct {
+	// Backend is one of TerminalBackends, "auto" picks the first installed one
+	Backend string `yaml:"backend"`
+	// Command is a text/template of the generic backend, with .AppID, .Title
+	// and .Cmd (shell-quoted)
+	Command string `yaml:"command"`
+}
+
+// WindowID is the title and app_id of the picker windows.
+const WindowID = "sway-yasm"
+
+// TerminalBackends are the valid values of terminal.backend.
+var TerminalBackends = []string{"auto", "foot", "footclient", "alacritty",
+	"kitty", "wezterm", "generic"}
+
+// RowFuncs are the functions of switcher.row_format, implemented by the
+// pickers in internal/cmds.
+var RowFuncs = []string{"pad", "trunc", "width"}
+
+type Watcher struct {
+	// max 1 refresh of a PATH dir per Debounce
+	Debounce time.Duration `yaml:"debounce"`
+}
+
+type Autoconfig struct {
+	// Rules are sway commands applied on each connection
+	Rules []string `yaml:"rules"`
+	// Clipman starts clipman, if it's not running
+	Clipman string `yaml:"clipman"`
+}
+
+// Default returns the built-in configuration.
+func Default() *Config {
+	return &Config{
+		Daemon: Daemon{
+			Autoconfig:   true,
+			MaxTracked:   100,
+			RPCTimeout:   time.Second * 5,
+			ReconnectMin: time.Millisecond * 500,
+			ReconnectMax: time.Second * 30,
+		},
+		Switcher: Switcher{
+			RowFormat: `{{pad 3 .OutputAlias}} | {{pad 8 .Workspace}} | ` +
+				`{{pad 15 .App}} | {{pad 40 .Title}}`,
+			LenSpace:   8,
+			LenDisplay: 3,
+			LenApps:    60,
+		},
+		Fzf: Fzf{
+			Switcher: `
+  fzf \
+    --prompt 'Switcher: ' \
+    --ansi \
+    --bind "load:pos(2)" \
+    --bind "change:pos(1)" \
+    --layout=reverse --info=hidden \
+    --bind=space:accept,tab:offset-down,btab:offset-up
+`,
+			SpaceSwitcher: `
+  fzf \
+    --prompt 'Workspace: ' \
+    --bind "load:pos(2)" \
+    --bind "change:pos(1)" \
+    --layout=reverse --info=hidden \
+    --bind=space:accept,tab:offset-down,btab:offset-up
+`,
+			PickWin: `
+  fzf \
+    --prompt 'Move which window to this workspace?: ' \
+    --layout=reverse --info=hidden \
+    --bind=space:accept,tab:offset-down,btab:offset-up
+`,
+			Clipboard: `
+  fzf \
+    --prompt 'Copy which one to the clipboard?: ' \
+    --layout=reverse --info=hidden \
+    --bind=space:accept,tab:offset-down,btab:offset-up
+`,
+			PickSpace: `
+  fzf \
+    --prompt 'Move which workspace to this output?: ' \
+    --layout=reverse --info=hidden \
+    --bind=space:accept,tab:offset-down,btab:offset-up
+`,
+			Path: `
+  fzf \
+    --prompt 'Run: ' \
+    --layout=reverse --info=hidden \
+    --bind=space:accept,tab:offset-down,btab:offset-up
+`,
+			// junegunn/seoul256.vim (light)
+			Light: ` \
+    --color=bg+:#D9D9D9,bg:#E1E1E1,border:#C8C8C8,spinner:#719899,hl:#719872,fg:#616161,header:#719872,info:#727100,pointer:#E12672,marker:#E17899,fg+:#616161,preview-bg:#D9D9D9,prompt:#0099BD,hl+:#719899
+`,
+		},
+		// the light themes follow junegunn/seoul256.vim, as fzf's
+		Picker: Picker{
+			Backend: "fzf",
+			Fuzzel: Dmenu{
+				Args: "--width 100 --lines 20",
+				Light: "--background e1e1e1ff --text-color 616161ff " +
+					"--match-color 719872ff --selection-color d9d9d9ff " +
+					"--selection-text-color 616161ff " +
+					"--selection-match-color 719899ff --border-color c8c8c8ff",
+			},
+			Wofi: Dmenu{
+				// wofi is themed by CSS, see --style
+				Args: "--width 1000 --lines 20",
+			},
+			Rofi: Dmenu{
+				Args: "-l 20",
+				Light: `-theme-str '* { background-color: #E1E1E1; ` +
+					`text-color: #616161; } element selected { ` +
+					`background-color: #D9D9D9; text-color: #E12672; }'`,
+			},
+			Bemenu: Dmenu{
+				Args: "-l 20",
+				Light: "--tb '#E1E1E1' --tf '#0099BD' --fb '#E1E1E1' " +
+					"--ff '#616161' --nb '#E1E1E1' --nf '#616161' " +
+					"--ab '#E1E1E1' --af '#616161' --hb '#D9D9D9' " +
+					"--hf '#E12672'",
+			},
+		},
+		Terminal: Terminal{
+			Backend: "auto",
+			Command: `xterm -class {{.AppID}} -title {{.Title}} -e {{.Cmd}}`,
+		},
+		Watcher: Watcher{
+			Debounce: time.Second,
+		},
+		Autoconfig: Autoconfig{
+			Rules: []string{
+				`for_window [title="sway-yasm"] floating enable`,
+				`for_window [title="sway-yasm"] border none`,
+				`for_window [title="sway-yasm"] sticky enable`,
+				`for_window [app_id="sway-yasm"] floating enable`,
+				`for_window [app_id="sway-yasm"] border none`,
+				`for_window [app_id="sway-yasm"] sticky enable`,
+			},
+			Clipman: "exec wl-paste -t text --watch clipman store " +
+				"--no-persist --max-items=200",
+		},
+	}
+}
+
+// Path returns the location of the config file.
+func Path() string {
+	if path := os.Getenv("YASM_CONFIG"); path != "" {
+		return path
+	}
+
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, _ := os.UserHomeDir()
+		dir = filepath.Join(home, ".config")
+	}
+
+	return filepath.Join(dir, "sway-yasm", "config.yaml")
+}
+
+// Load reads the config file on top of the defaults. A missing file isn't an
+// error.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	cfg, err = Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// Parse decodes a YAML document on top of the defaults and validates it.
+func Parse(data []byte) (*Config, error) {
+	cfg := Default()
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	err := dec.Decode(cfg)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Validate checks the values, returning an error for the first invalid key.
+func (c *Config) Validate() error {
+	positive := []struct {
+		key string
+		val int64
+	}{
+		{"daemon.max_tracked", int64(c.Daemon.MaxTracked)},
+		{"daemon.rpc_timeout", int64(c.Daemon.RPCTimeout)},
+		{"daemon.reconnect_min", int64(c.Daemon.ReconnectMin)},
+		{"daemon.reconnect_max", int64(c.Daemon.ReconnectMax)},
+		{"switcher.len_space", int64(c.Switcher.LenSpace)},
+		{"switcher.len_display", int64(c.Switcher.LenDisplay)},
+		{"switcher.len_apps", int64(c.Switcher.LenApps)},
+		{"watcher.debounce", int64(c.Watcher.Debounce)},
+	}
+	for _, p := range positive {
+		if p.val <= 0 {
+			return fmt.Errorf("%s: must be greater than 0", p.key)
+		}
+	}
+
+	if c.Daemon.ReconnectMax < c.Daemon.ReconnectMin {
+		return fmt.Errorf("daemon.reconnect_max: must not be lower than " +
+			"daemon.reconnect_min")
+	}
+
+	required := []struct {
+		key string
+		val string
+	}{
+		{"switcher.row_format", c.Switcher.RowFormat},
+		{"fzf.switcher", c.Fzf.Switcher},
+		{"fzf.space_switcher", c.Fzf.SpaceSwitcher},
+		{"fzf.pick_win", c.Fzf.PickWin},
+		{"fzf.pick_space", c.Fzf.PickSpace},
+		{"fzf.clipboard", c.Fzf.Clipboard},
+		{"fzf.path", c.Fzf.Path},
+	}
+	for _, r := range required {
+		if strings.TrimSpace(r.val) == "" {
+			return fmt.Errorf("%s: can't be empty", r.key)
+		}
+	}
+
+	if !slices.Contains(PickerBackends, c.Picker.Backend) {
+		return fmt.Errorf("picker.backend: must be one of %s",
+			strings.Join(PickerBackends, ", "))
+	}
+	if !slices.Contains(TerminalBackends, c.Terminal.Backend) {
+		return fmt.Errorf("terminal.backend: must be one of %s",
+			strings.Join(TerminalBackends, ", "))
+	}
+	if c.Terminal.Backend == "generic" &&
+		strings.TrimSpace(c.Terminal.Command) == "" {
+
+		return fmt.Errorf("terminal.command: can't be empty for the generic " +
+			"backend")
+	}
+	if _, err := template.New("").Parse(c.Terminal.Command); err != nil {
+		return fmt.Errorf("terminal.command: %w", err)
+	}
+
+	// parse only, the functions get called by the pickers
+	funcs := template.FuncMap{}
+	for _, name := range RowFuncs {
+		funcs[name] = func() string { return "" }
+	}
+	if _, err := template.New("").Funcs(funcs).
+		Parse(c.Switcher.RowFormat); err != nil {
+		return fmt.Errorf("switcher.row_format: %w", err)
+	}
+
+	return nil
+}
+
+// YAML returns the config as a YAML document.
+func (c *Config) YAML() (string, error) {
+	return encode(c)
+}
+
+// Get returns the value of a dotted key, eg "daemon.max_tracked", as YAML.
+func (c *Config) Get(key string) (string, error) {
+	root, err := c.node()
+	if err != nil {
+		return "", err
+	}
+	node, err := lookup(root, key)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := encode(node)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(out, "\n"), nil
+}
+
+// With returns a validated copy of the config, with the dotted key set to the
+// value. Scalars are taken as they are, other values are parsed as YAML.
+func (c *Config) With(key, value string) (*Config, error) {
+	root, err := c.node()
+	if err != nil {
+		return nil, err
+	}
+	node, err := lookup(root, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if node.Kind == yaml.ScalarNode {
+		*node = yaml.Node{Kind: yaml.ScalarNode, Value: value}
+	} else {
+		var doc yaml.Node
+		if err := yaml.Unmarshal([]byte(value), &doc); err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		if len(doc.Content) == 0 {
+			return nil, fmt.Errorf("%s: can't be empty", key)
+		}
+		*node = *doc.Content[0]
+	}
+
+	cfg := &Config{}
+	if err := root.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// node returns the config as a YAML mapping node.
+func (c *Config) node() (*yaml.Node, error) {
+	var root yaml.Node
+	if err := root.Encode(c); err != nil {
+		return nil, err
+	}
+
+	return &root, nil
+}
+
+// lookup returns the node of a dotted key.
+func lookup(node *yaml.Node, key string) (*yaml.Node, error) {
+	path := strings.Split(key, ".")
+	for i, name := range path {
+		if node.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("%s: unknown key",
+				strings.Join(path[:i+1], "."))
+		}
+
+		var next *yaml.Node
+		for ii := 0; ii+1 < len(node.Content); ii += 2 {
+			if node.Content[ii].Value == name {
+				next = node.Content[ii+1]
+				break
+			}
+		}
+		if next == nil {
+			return nil, fmt.Errorf("%s: unknown key",
+				strings.Join(path[:i+1], "."))
+		}
+		node = next
+	}
+
+	return node, nil
+}
+
+func encode(v any) (string, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,197 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/rpc"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pancsta/sway-yasm/internal/config"
+)
+
+var (
+	// ErrDaemonNotRunning means nothing listens on the RPC socket.
+	ErrDaemonNotRunning = errors.New("daemon not running")
+	// ErrDaemonTimeout means the daemon didn't reply within
+	// daemon.rpc_timeout.
+	ErrDaemonTimeout = errors.New("daemon not responding")
+	// ErrProtocolMismatch means the client and the daemon come from
+	// incompatible versions, see ProtocolVersion.
+	ErrProtocolMismatch = errors.New("protocol mismatch")
+)
+
+// MethodError is returned by RemoteCall when the RPC method itself failed.
+type MethodError struct {
+	Method string
+	Msg    string
+}
+
+func (e *MethodError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Method, e.Msg)
+}
+
+// SpawnDaemon makes RemoteCall start the daemon in the background, when it's
+// not running.
+var SpawnDaemon bool
+
+var (
+	// client is reused between calls and guarded by clientMx
+	client    *rpc.Client
+	clientCfg *config.Config
+	clientMx  sync.Mutex
+)
+
+// RemoteCall calls the daemon's RPC method, eg "Daemon.RemoteWindows", with
+// the method's args and reply types. Returns ErrDaemonNotRunning,
+// ErrDaemonTimeout, ErrProtocolMismatch, ErrSwayUnavailable or MethodError. A
+// broken connection, eg after restarting the daemon, is retried once.
+func RemoteCall(method string, args, reply any) error {
+	log.Printf("rpcCall %s...", method)
+	clientMx.Lock()
+	defer clientMx.Unlock()
+
+	if clientCfg == nil {
+		clientCfg = clientConfig()
+	}
+
+	err := call(clientCfg, method, args, reply)
+	if errors.Is(err, rpc.ErrShutdown) || errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+
+		closeClient()
+		err = call(clientCfg, method, args, reply)
+	}
+
+	return err
+}
+
+// ClientTimeout returns the daemon.rpc_timeout of RemoteCall.
+func ClientTimeout() time.Duration {
+	clientMx.Lock()
+	defer clientMx.Unlock()
+
+	if clientCfg == nil {
+		clientCfg = clientConfig()
+	}
+
+	return clientCfg.Daemon.RPCTimeout
+}
+
+func call(cfg *config.Config, method string, args, reply any) error {
+	if client == nil {
+		conn, err := dial(cfg)
+		if err != nil {
+			return err
+		}
+		client = rpc.NewClient(conn)
+
+		// negotiate the protocol
+		err = invoke(cfg, "Daemon.Hello", HelloArgs{
+			Version: ProtocolVersion,
+			Client:  Version(),
+		}, &HelloReply{})
+		var methodErr *MethodError
+		if errors.As(err, &methodErr) {
+			closeClient()
+			if strings.Contains(methodErr.Msg, "can't find method") {
+				return fmt.Errorf("%w: the daemon is older than the client "+
+					"(v%d), restart it", ErrProtocolMismatch, ProtocolVersion)
+			}
+			return fmt.Errorf("%w: %s", ErrProtocolMismatch, methodErr.Msg)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return invoke(cfg, method, args, reply)
+}
+
+// invoke calls the method within daemon.rpc_timeout.
+func invoke(cfg *config.Config, method string, args, reply any) error {
+	timeout := time.NewTimer(cfg.Daemon.RPCTimeout)
+	defer timeout.Stop()
+	c := client.Go(method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-c.Done:
+	case <-timeout.C:
+		// the connection is in an unknown state
+		closeClient()
+		return fmt.Errorf("%w: %s", ErrDaemonTimeout, method)
+	}
+
+	var serverErr rpc.ServerError
+	if errors.As(c.Error, &serverErr) {
+		if strings.Contains(string(serverErr), ErrSwayUnavailable.Error()) {
+			return ErrSwayUnavailable
+		}
+		return &MethodError{Method: method, Msg: string(serverErr)}
+	}
+
+	return c.Error
+}
+
+func closeClient() {
+	if client != nil {
+		client.Close()
+		client = nil
+	}
+}
+
+// dial connects to the daemon's socket, optionally spawning the daemon.
+func dial(cfg *config.Config) (net.Conn, error) {
+	sock := clientSocketPath(cfg)
+	conn, err := net.DialTimeout("unix", sock, cfg.Daemon.RPCTimeout)
+	if err != nil && SpawnDaemon {
+		conn, err = spawnDaemon(sock, cfg.Daemon.RPCTimeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDaemonNotRunning, err)
+	}
+
+	return conn, nil
+}
+
+// spawnDaemon starts the daemon in a new session and waits for its socket.
+func spawnDaemon(sock string, timeout time.Duration) (net.Conn, error) {
+	log.Printf("spawning the daemon...")
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(exe, "daemon")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	// reap the daemon, if it exits before the client
+	go cmd.Wait()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		conn, err := net.Dial("unix", sock)
+		if err == nil || time.Now().After(deadline) {
+			return conn, err
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// clientConfig reads the config file for the daemon's socket, falling back to
+// the defaults.
+func clientConfig() *config.Config {
+	cfg, err := config.Load(config.Path())
+	if err != nil {
+		log.Printf("error: %s", err)
+		return config.Default()
+	}
+
+	return cfg
+}
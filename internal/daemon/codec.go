@@ -0,0 +1,78 @@
+package daemon
+
+import (
+	"bufio"
+	"encoding/gob"
+	"io"
+	"net/rpc"
+	"reflect"
+)
+
+// helloCodec is the gob server codec of net/rpc, which routes all the calls
+// before Daemon.Hello to Daemon.RemoteUnsupported. This way, clients from
+// before the handshake get a readable error, instead of gob decoding errors.
+type helloCodec struct {
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+	hello  bool
+	// skip is true when the current request's body should be discarded
+	skip bool
+}
+
+func newHelloCodec(conn io.ReadWriteCloser) *helloCodec {
+	buf := bufio.NewWriter(conn)
+	return &helloCodec{
+		rwc:    conn,
+		dec:    gob.NewDecoder(conn),
+		enc:    gob.NewEncoder(buf),
+		encBuf: buf,
+	}
+}
+
+func (c *helloCodec) ReadRequestHeader(r *rpc.Request) error {
+	err := c.dec.Decode(r)
+	if err != nil {
+		return err
+	}
+
+	c.skip = false
+	switch {
+	case r.ServiceMethod == "Daemon.Hello":
+		c.hello = true
+	case !c.hello:
+		c.skip = true
+		r.ServiceMethod = "Daemon.RemoteUnsupported"
+	}
+
+	return nil
+}
+
+func (c *helloCodec) ReadRequestBody(body any) error {
+	if c.skip {
+		// the body's type is unknown, discard it
+		return c.dec.DecodeValue(reflect.Value{})
+	}
+
+	return c.dec.Decode(body)
+}
+
+func (c *helloCodec) WriteResponse(r *rpc.Response, body any) error {
+	err := c.enc.Encode(r)
+	if err == nil {
+		err = c.enc.Encode(body)
+	}
+	if err == nil {
+		err = c.encBuf.Flush()
+	}
+	if err != nil {
+		c.Close()
+	}
+
+	return err
+}
+
+func (c *helloCodec) Close() error {
+	return c.rwc.Close()
+}
@@ -0,0 +1,131 @@
+package daemon
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/pancsta/gosway/ipc"
+)
+
+// memCompositor is an in-memory compositor. It serves a static tree, records
+// the commands instead of running them and passes events from emit to the
+// subscribers.
+type memCompositor struct {
+	mx       sync.Mutex
+	tree     *ipc.Tree
+	spaces   []*ipc.Workspace
+	commands []string
+	subs     map[*subscription][]string
+	closed   bool
+}
+
+var _ compositor = &memCompositor{}
+
+func newMemCompositor(tree *ipc.Tree, spaces []*ipc.Workspace) *memCompositor {
+	if tree == nil {
+		tree = &ipc.Tree{}
+	}
+
+	return &memCompositor{
+		tree:   tree,
+		spaces: spaces,
+		subs:   map[*subscription][]string{},
+	}
+}
+
+func (c *memCompositor) GetTree() (*ipc.Tree, error) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if c.closed {
+		return nil, ErrSwayUnavailable
+	}
+
+	return c.tree, nil
+}
+
+func (c *memCompositor) GetWorkspaces() ([]*ipc.Workspace, error) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if c.closed {
+		return nil, ErrSwayUnavailable
+	}
+
+	return c.spaces, nil
+}
+
+// RunCommand records the command, without changing the tree.
+func (c *memCompositor) RunCommand(cmd string) error {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if c.closed {
+		return ErrSwayUnavailable
+	}
+	c.commands = append(c.commands, cmd)
+
+	return nil
+}
+
+func (c *memCompositor) Subscribe(events []string) (*subscription, error) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if c.closed {
+		return nil, ErrSwayUnavailable
+	}
+	s := newSubscription(nil)
+	c.subs[s] = events
+
+	return s, nil
+}
+
+func (c *memCompositor) Close() error {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	c.closed = true
+
+	return nil
+}
+
+// Commands returns the recorded commands.
+func (c *memCompositor) Commands() []string {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	return slices.Clone(c.commands)
+}
+
+// setTree replaces the tree, eg before emitting a "reload" event.
+func (c *memCompositor) setTree(tree *ipc.Tree, spaces []*ipc.Workspace) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	c.tree = tree
+	c.spaces = spaces
+}
+
+// emit passes the event to the subscribers of its type, blocking until each
+// one receives it or gets closed.
+func (c *memCompositor) emit(e swayEvent) {
+	c.mx.Lock()
+	var subs []*subscription
+	for s, events := range c.subs {
+		if slices.Contains(events, eventNames[e.Type]) {
+			subs = append(subs, s)
+		}
+	}
+	c.mx.Unlock()
+
+	for _, s := range subs {
+		select {
+		case s.Events <- e:
+		case <-s.done:
+			c.mx.Lock()
+			delete(c.subs, s)
+			c.mx.Unlock()
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package daemon
+
+import (
+	"errors"
+	"reflect"
+	"time"
+
+	"github.com/pancsta/sway-yasm/internal/config"
+)
+
+// wait for the editor to finish writing the config file
+const configDebounce = time.Millisecond * 200
+
+// loadConfig reads the config file and applies the overrides.
+func (d *Daemon) loadConfig() (*config.Config, error) {
+	cfg := config.Default()
+	if d.ConfigPath != "" {
+		var err error
+		cfg, err = config.Load(d.ConfigPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for key, val := range d.Overrides {
+		var err error
+		cfg, err = cfg.With(key, val)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return cfg, nil
+}
+
+// reloadConfig re-reads the config file, keeping the current config on
+// errors.
+func (d *Daemon) reloadConfig() {
+	d.Logger.Printf("config changed, reloading...")
+	cfg, err := d.loadConfig()
+	if err == nil {
+		err = d.updateConfig(func(*config.Config) (*config.Config, error) {
+			return cfg, nil
+		})
+	}
+	if err != nil {
+		d.Logger.Printf("config error: %s", err)
+	}
+}
+
+// currentConfig returns the config in use. It gets replaced on changes, but
+// never modified.
+func (d *Daemon) currentConfig() *config.Config {
+	var cfg *config.Config
+	d.exec(func(st *state) {
+		cfg = st.cfg
+	})
+
+	return cfg
+}
+
+// updateConfig replaces the config with the result of update and applies the
+// changes to the running daemon.
+func (d *Daemon) updateConfig(
+	update func(prev *config.Config) (*config.Config, error),
+) error {
+	var prev, cfg *config.Config
+	var err error
+	var killWarm int
+	spawnWarm := false
+	resetPointer := false
+	d.exec(func(st *state) {
+		prev = st.cfg
+		cfg, err = update(prev)
+		if err != nil {
+			return
+		}
+		st.cfg = cfg
+
+		// replace the warm picker on a backend change, forgotten before
+		// closing, so it's not respawned. Its sessions get reaped on close.
+		if st.warm != nil && (!warmPickerEnabled(cfg) ||
+			st.warm.backend != cfg.Picker.Backend) {
+
+			killWarm = st.warm.conID
+			st.warm = nil
+		}
+		spawnWarm = st.warm == nil && warmPickerEnabled(cfg) &&
+			(killWarm != 0 || !warmPickerEnabled(prev) ||
+				prev.Picker.Backend != cfg.Picker.Backend)
+
+		// trim the MRU lists
+		max := cfg.Daemon.MaxTracked
+		if len(st.winFocus) > max {
+			for _, id := range st.winFocus[max:] {
+				delete(st.winData, id)
+			}
+			st.winFocus = st.winFocus[:max]
+		}
+		if len(st.spaceFocus) > max {
+			st.spaceFocus = st.spaceFocus[:max]
+		}
+
+		if prev.Daemon.MouseFollowsFocus && !cfg.Daemon.MouseFollowsFocus {
+			st.mouseInOutput = ""
+			resetPointer = true
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	d.events.publish(Event{Type: EventConfig})
+	d.watcher.SetDebounce(cfg.Watcher.Debounce)
+	if prev.Daemon.RPCSocket != cfg.Daemon.RPCSocket {
+		d.Logger.Printf("RPC socket changes require a restart")
+	}
+
+	// set the pointer to all the outputs
+	if resetPointer {
+		err = d.SwayMsg(`input 0:0:wlr_virtual_pointer_v1 map_to_output "*"`)
+	}
+
+	// re-apply the sway config, if changed
+	autoconfigChanged := !prev.Daemon.Autoconfig ||
+		!reflect.DeepEqual(prev.Autoconfig, cfg.Autoconfig)
+	if err == nil && cfg.Daemon.Autoconfig && autoconfigChanged {
+		err = d.autoconfig(cfg)
+	}
+	if err == nil && cfg.Daemon.DefaultKeybindings &&
+		!prev.Daemon.DefaultKeybindings {
+		err = d.defaultKeybinding()
+	}
+	if err == nil && killWarm != 0 {
+		err = d.SwayMsg(`[con_id=%d] kill`, killWarm)
+	}
+	if err == nil && spawnWarm {
+		err = d.spawnWarmPicker()
+	}
+	// applied again after reconnecting
+	if errors.Is(err, ErrSwayUnavailable) {
+		return nil
+	}
+
+	return err
+}
@@ -10,47 +10,49 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pancsta/gosway/ipc"
 	"github.com/samber/lo"
 
+	"github.com/pancsta/sway-yasm/internal/config"
 	"github.com/pancsta/sway-yasm/internal/types"
 	"github.com/pancsta/sway-yasm/internal/watcher"
 	usrCmds "github.com/pancsta/sway-yasm/pkg/usr-cmds"
 )
 
-// CONFIG TODO file
-const (
-	maxTracked = 100
-	lenSpace   = 8
-	lenDisplay = 3
-	lenApp     = 15
-	lenTitle   = 40
-	rpcHost    = "localhost:7853"
-	rpcHostDbg = "localhost:7854"
-	// how long a PID can hold the switcher
-	pidTimeout = time.Second * 3
-)
-
-// config end
-
 type WindowFocus []string
 
 type Daemon struct {
-	conn               *ipc.SwayConnection
-	MouseFollowsFocus  bool
-	watcher            *watcher.PathWatcher
-	ctx                context.Context
-	winFocus           WindowFocus
-	winData            map[string]types.WindowData
-	openedByPID        int
-	openedAt           time.Time
-	Autoconfig         bool
-	DefaultKeybindings bool
-	Logger             *log.Logger
-	// current mouse output
-	mouseInOutput string
+	// conn is guarded by connMx, which also serializes IPC requests
+	conn   compositor
+	connMx sync.Mutex
+	// dial opens the compositor connection, nil means sway (see dialSway)
+	dial    func() (compositor, error)
+	watcher *watcher.PathWatcher
+	ctx     context.Context
+	Logger  *log.Logger
+	// startedAt is set once in Start
+	startedAt time.Time
+	// ConfigPath is watched for changes, empty means the defaults
+	ConfigPath string
+	// Overrides are dotted keys applied on top of the config file, eg from
+	// the CLI flags
+	Overrides map[string]string
+	// RecordPath is a file to record the session to, for Replay
+	RecordPath string
+	recorder   *recorder
+	// DryRun makes sway commands only logged and simulated on the state,
+	// see dryRunCommand
+	DryRun bool
+	dryRun *commandLog
+
+	// state is owned by loop, see exec
+	state *state
+	queue chan func()
+	// events are published to subscribers, see Subscribe
+	events *eventBus
 }
 
 // API compat check
@@ -70,90 +72,357 @@ func isClipmanRunning() bool {
 }
 
 func (d *Daemon) Start() {
-	var err error
 	d.ctx = context.Background()
+	d.startedAt = time.Now()
+
+	cfg, err := d.loadConfig()
+	if err != nil {
+		d.Logger.Fatalf("config error: %s", err)
+	}
+	if cfg.Daemon.MouseFollowsFocus {
+		d.Logger.Println("Mouse follows focus enabled")
+	}
+
+	if d.RecordPath != "" {
+		d.recorder, err = newRecorder(d.RecordPath)
+		if err != nil {
+			d.Logger.Fatalf("record error: %s", err)
+		}
+		defer d.recorder.Close()
+		d.Logger.Printf("Recording to %s", d.RecordPath)
+	}
+
+	if d.DryRun {
+		d.dryRun = &commandLog{}
+		d.Logger.Println("Dry run, sway commands won't be executed")
+	}
+
+	d.state = newState(cfg)
+	d.queue = make(chan func())
+	d.events = newEventBus()
+	go d.loop()
 
-	d.winData = make(map[string]types.WindowData)
 	d.watcher, err = watcher.New(d.ctx, d.Logger)
 	if err != nil {
 		d.Logger.Fatalf("error: %s", err)
 	}
+	d.watcher.Debounce = cfg.Watcher.Debounce
+	d.watcher.OnRefreshed = func(count int) {
+		d.events.publish(Event{Type: EventPathRefresh, Count: count})
+	}
 
-	// connect
-	conn, err := ipc.NewSwayConnection()
+	// the RPC server stays up between sway reconnections
+	sock := rpcSocketPath(cfg)
+	l, err := listenRPC(sock)
 	if err != nil {
-		d.Logger.Fatal(err)
+		d.Logger.Fatalf("error: %s", err)
+	}
+	// closing removes the socket file
+	defer l.Close()
+	go rpcServer(d.Logger, d, &peerListener{UnixListener: l, logger: d.Logger})
+	d.Logger.Printf("RPC listening on %s", sock)
+	d.watcher.Start()
+
+	if d.ConfigPath != "" {
+		err = watcher.WatchFile(d.ctx, d.ConfigPath, configDebounce,
+			d.reloadConfig)
+		if err != nil {
+			d.Logger.Printf("error: %s", err)
+		}
+	}
+
+	attempt := 0
+	for {
+		s, err := d.connect()
+		if err != nil {
+			cfg := d.currentConfig()
+			delay := backoff(attempt, cfg.Daemon.ReconnectMin,
+				cfg.Daemon.ReconnectMax)
+			attempt++
+			d.Logger.Printf("error: %s, reconnecting in %s", err, delay)
+			time.Sleep(delay)
+			continue
+		}
+		attempt = 0
+
+		d.Logger.Printf("Listening for sway events...")
+		err = d.listen(s)
+		d.disconnect(s)
+		if errors.Is(err, errSwayShutdown) {
+			d.Logger.Printf("sway exited, stopping...")
+			d.watcher.Stop()
+			return
+		}
+		d.Logger.Printf("error: %s, reconnecting...", err)
+	}
+}
+
+// connect opens both IPC connections, re-reads the tree and re-applies the
+// sway config.
+func (d *Daemon) connect() (*subscription, error) {
+	dial := d.dial
+	if dial == nil {
+		dial = dialSway
+	}
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+
+	// subscribe before reading the tree, so no event gets lost
+	s, err := conn.Subscribe([]string{"window", "workspace", "output", "mode",
+		"shutdown"})
+	if err != nil {
+		conn.Close()
+		return nil, err
 	}
-	d.conn = conn
 
 	// read the existing tree to fill out the MRU list
 	tree, err := conn.GetTree()
+	var spaces []*ipc.Workspace
+	if err == nil {
+		spaces, err = conn.GetWorkspaces()
+	}
 	if err != nil {
-		d.Logger.Fatal("error:", err)
+		s.Close()
+		conn.Close()
+		return nil, err
 	}
-	for _, output := range tree.Nodes {
-		for _, workspace := range output.Nodes {
-			for _, container := range workspace.Nodes {
-				d.parseNode(&container, workspace.Name, output.Name)
+	d.record(record{Kind: recordConnect, Tree: tree, Workspaces: spaces,
+		Config: d.recordedConfig()})
+	spawnWarm := false
+	d.exec(func(st *state) {
+		rebuild(st, tree, spaces)
+		// the picker windows don't survive a sway restart
+		for id, s := range st.pickers {
+			if s.conID != 0 && !hasCon(tree.Nodes, s.conID) {
+				delete(st.pickers, id)
 			}
 		}
+		if st.warm != nil && !hasCon(tree.Nodes, st.warm.conID) {
+			st.warm = nil
+		}
+		spawnWarm = st.warm == nil && warmPickerEnabled(st.cfg)
+	})
+
+	d.connMx.Lock()
+	d.conn = conn
+	d.connMx.Unlock()
+
+	cfg := d.currentConfig()
+	if cfg.Daemon.Autoconfig {
+		err = d.autoconfig(cfg)
+	}
+	if err == nil && cfg.Daemon.DefaultKeybindings {
+		err = d.defaultKeybinding()
+	}
+	if err == nil && spawnWarm {
+		err = d.spawnWarmPicker()
+	}
+	if err != nil {
+		d.disconnect(s)
+		return nil, err
 	}
 
-	if d.Autoconfig {
-		err = d.autoconfig(err)
+	return s, nil
+}
+
+// disconnect closes both IPC connections.
+func (d *Daemon) disconnect(s *subscription) {
+	s.Close()
+
+	d.connMx.Lock()
+	if d.conn != nil {
+		d.conn.Close()
+		d.conn = nil
 	}
+	d.connMx.Unlock()
+
+	d.exec(func(st *state) {
+		st.mouseInOutput = ""
+	})
+}
+
+// sway returns the current IPC connection, or ErrSwayUnavailable while
+// reconnecting. Only for commands, use getTree and getWorkspaces for
+// requests.
+func (d *Daemon) sway() (compositor, error) {
+	d.connMx.Lock()
+	defer d.connMx.Unlock()
 
-	if d.DefaultKeybindings {
-		err = d.defaultKeybinding(err)
+	if d.conn == nil {
+		return nil, ErrSwayUnavailable
 	}
 
-	// subscribe to events
-	subCon, err := ipc.NewSwayConnection()
-	if err != nil {
-		d.Logger.Fatal(err)
+	return d.conn, nil
+}
+
+// getTree requests the tree over the shared connection.
+func (d *Daemon) getTree() (*ipc.Tree, error) {
+	d.connMx.Lock()
+	defer d.connMx.Unlock()
+
+	if d.conn == nil {
+		return nil, ErrSwayUnavailable
+	}
+
+	return d.conn.GetTree()
+}
+
+// runCommand runs a sway command over the shared connection.
+func (d *Daemon) runCommand(cmd string) error {
+	if d.DryRun {
+		return d.dryRunCommand(cmd)
+	}
+
+	d.connMx.Lock()
+	defer d.connMx.Unlock()
+
+	if d.conn == nil {
+		return ErrSwayUnavailable
 	}
 
-	// Subscribe only to the window related events
-	_, err = subCon.SendCommand(ipc.IPC_SUBSCRIBE, `["window"]`)
+	err := d.conn.RunCommand(cmd)
+	rec := record{Kind: recordCommand, Command: cmd}
 	if err != nil {
-		d.Logger.Fatal(err)
+		rec.Error = err.Error()
 	}
+	d.record(rec)
 
-	// Listen for the events
-	s := subCon.Subscribe()
-	defer s.Close()
+	return err
+}
 
-	go rpcServer(d.Logger, d)
-	d.watcher.Start()
-	d.Logger.Printf("Listening for sway events...")
+// getWorkspaces requests the list of workspaces over the shared connection.
+func (d *Daemon) getWorkspaces() ([]*ipc.Workspace, error) {
+	d.connMx.Lock()
+	defer d.connMx.Unlock()
+
+	if d.conn == nil {
+		return nil, ErrSwayUnavailable
+	}
 
+	return d.conn.GetWorkspaces()
+}
+
+// listen handles sway events until the subscription fails.
+func (d *Daemon) listen(s *subscription) error {
 	for {
 		select {
 
 		case event := <-s.Events:
 			if isLog() {
-				d.Logger.Printf("Event: %s #%d", event.Change, event.Container.ID)
+				d.Logger.Printf("Event: %s %s #%d", eventNames[event.Type],
+					event.Change, event.Container.ID)
+			}
+			d.record(record{Kind: recordEvent, EventType: eventNames[event.Type],
+				Event: &event})
+			if event.Type == eventShutdown {
+				return errSwayShutdown
 			}
 
-			if event.Change == "focus" {
-				d.onFocus("focus", &event.Container)
+			data, ok := d.handleEvent(&event)
+
+			// run user scripts outside of the state loop
+			if ok {
+				d.runListeners(event.Change, data)
 			}
-			if event.Change == "new" {
-				d.onFocus("new", &event.Container)
+
+		case err := <-s.Errors:
+			return err
+		}
+	}
+}
+
+// handleEvent updates the state and publishes the resulting events. Returns
+// the window data for the user listeners, if any.
+func (d *Daemon) handleEvent(e *swayEvent) (types.WindowData, bool) {
+	var (
+		data   types.WindowData
+		ok     bool
+		events []Event
+	)
+	respawn := false
+	d.exec(func(st *state) {
+		prevFocus := slices.Clone(st.winFocus)
+		data, ok = d.onEvent(st, e)
+		events = stateEvents(st, e, data, ok, prevFocus)
+		respawn = d.reapPickers(st, e)
+	})
+	for _, ev := range events {
+		d.events.publish(ev)
+	}
+	if respawn {
+		if err := d.spawnWarmPicker(); err != nil {
+			d.Logger.Printf("error: %s", err)
+		}
+	}
+
+	return data, ok
+}
+
+// runListeners passes the window to the user's listeners of the event.
+func (d *Daemon) runListeners(event string, data types.WindowData) {
+	for _, l := range usrCmds.Listeners[event] {
+		l.WinListenerFunc(d, data)
+	}
+}
+
+// rebuild re-reads all the windows and workspaces, while keeping the MRU
+// order of the windows which still exist.
+func rebuild(st *state, tree *ipc.Tree, spaces []*ipc.Workspace) {
+	prevFocus := st.winFocus
+	prevSpaceFocus := st.spaceFocus
+	st.winData = make(map[string]types.WindowData)
+	st.winFocus = nil
+	st.spaces = make(map[string]types.SpaceData)
+	st.spaceFocus = nil
+
+	for _, space := range spaces {
+		id := strconv.Itoa(int(space.ID))
+		st.spaces[id] = types.SpaceData{
+			ID:      int(space.ID),
+			Name:    space.Name,
+			Output:  space.Output,
+			Visible: space.Visible,
+		}
+		st.spaceFocus = append(st.spaceFocus, id)
+		if space.Focused {
+			st.focusedSpace = id
+		}
+	}
+
+	// keep the MRU order of the workspaces, with the focused one on top
+	for i := len(prevSpaceFocus) - 1; i >= 0; i-- {
+		id := prevSpaceFocus[i]
+		if _, ok := st.spaces[id]; ok {
+			st.spaceFocus, _ = st.unshift(st.spaceFocus, id)
+		}
+	}
+	if st.focusedSpace != "" {
+		st.spaceFocus, _ = st.unshift(st.spaceFocus, st.focusedSpace)
+	}
+
+	for _, output := range tree.Nodes {
+		for _, workspace := range output.Nodes {
+			for _, container := range workspace.Nodes {
+				parseNode(st, &container, workspace.Name, output.Name)
 			}
-			if event.Change == "close" {
-				d.onClose(&event.Container)
+			for _, container := range workspace.FloatingNodes {
+				parseNode(st, &container, workspace.Name, output.Name)
 			}
+		}
+	}
 
-		case err := <-s.Errors:
-			// TODO reconnect / backoff
-			log.Println("Error:", err)
-			os.Exit(1)
+	// move the previously tracked windows to the front, in the same order
+	for i := len(prevFocus) - 1; i >= 0; i-- {
+		id := prevFocus[i]
+		if _, ok := st.winData[id]; ok {
+			st.winFocus, _ = st.unshift(st.winFocus, id)
 		}
 	}
 }
 
-func (d *Daemon) defaultKeybinding(err error) error {
+func (d *Daemon) defaultKeybinding() error {
 	var msgs []string
 	if isDev() {
 		msgs = []string{
@@ -175,41 +444,28 @@ func (d *Daemon) defaultKeybinding(err error) error {
 		}
 	}
 
-	err = d.SwayMsgs(msgs)
-	if err != nil {
-		d.Logger.Fatal("error:", err)
-	}
-
-	return err
+	return d.SwayMsgs(msgs)
 }
 
-func (d *Daemon) autoconfig(err error) error {
-	msgs := []string{
-		`for_window [title="sway-yasm"] floating enable`,
-		`for_window [title="sway-yasm"] border none`,
-		`for_window [title="sway-yasm"] sticky enable`,
-	}
-	err = d.SwayMsgs(msgs)
+func (d *Daemon) autoconfig(cfg *config.Config) error {
+	err := d.SwayMsgs(cfg.Autoconfig.Rules)
 	if err != nil {
-		d.Logger.Fatal("error:", err)
+		return err
 	}
 
-	if !isClipmanRunning() {
+	clipman := cfg.Autoconfig.Clipman
+	if clipman != "" && !isClipmanRunning() {
 		d.Logger.Printf("clipman not running, starting...")
 
-		err = d.SwayMsg("exec wl-paste -t text --watch clipman store " +
-			"--no-persist --max-items=200")
-		if err != nil {
-			d.Logger.Fatal("error:", err)
-		}
+		return d.SwayMsg("%s", clipman)
 	}
 
-	return err
+	return nil
 }
 
 // ListSpaces returns names of the current workspaces.
 func (d *Daemon) ListSpaces(skipOutputs []string) ([]string, error) {
-	tree, err := d.conn.GetTree()
+	tree, err := d.getTree()
 	if err != nil {
 		return nil, err
 	}
@@ -231,7 +487,7 @@ func (d *Daemon) ListSpaces(skipOutputs []string) ([]string, error) {
 // GetWinTreePath returns the nodes between tree root and the passed window ID,
 // starting with the workspace
 func (d *Daemon) GetWinTreePath(id int) ([]*ipc.Node, error) {
-	tree, err := d.conn.GetTree()
+	tree, err := d.getTree()
 	if err != nil {
 		return nil, err
 	}
@@ -272,14 +528,22 @@ func findPathToRoot(node *ipc.Node, targetID int64, path []*ipc.Node) (bool, []*
 			return true, p
 		}
 	}
+	for i := range node.FloatingNodes {
+		found, p := findPathToRoot(&node.FloatingNodes[i], targetID, path)
+		if found {
+			return true, p
+		}
+	}
 
 	// Target not found in this subtree
 	return false, nil
 }
 
-func (d *Daemon) parseNode(con *ipc.Node, space, output string) {
+func parseNode(st *state, con *ipc.Node, space, output string) {
+	appID, _ := con.AppID.(string)
+	isPicker := con.Name == config.WindowID || appID == config.WindowID
 	isWin := con.Layout != "splith" && con.Layout != "splitv" &&
-		con.Layout != "tabbed" && con.Layout != "stacked"
+		con.Layout != "tabbed" && con.Layout != "stacked" && !isPicker
 
 	if isWin {
 		id := strconv.Itoa(int(con.ID))
@@ -290,101 +554,44 @@ func (d *Daemon) parseNode(con *ipc.Node, space, output string) {
 			Title:     con.Name,
 			App:       con.WindowProperties.Class,
 			Rect:      con.Rect,
+			Floating:  con.Type == "floating_con",
+			Urgent:    con.Urgent,
 		}
-		if con.AppID != nil {
-			data.App = con.AppID.(string)
+		if appID != "" {
+			data.App = appID
 		}
 
-		d.winData[id] = data
-		d.winFocus, _ = unshiftAndTrim(d.winFocus, id)
+		st.winData[id] = data
+		st.winFocus, _ = st.unshift(st.winFocus, id)
 	}
 
 	for _, node := range con.Nodes {
-		d.parseNode(&node, space, output)
-	}
-}
-
-func (d *Daemon) onClose(c *ipc.Container) {
-	id := strconv.Itoa(c.ID)
-
-	// remove ID from winFocus
-	d.winFocus = lo.Without(d.winFocus, id)
-
-	// remove from winData
-	data := d.winData[id]
-	delete(d.winData, id)
-
-	// run user scripts
-	for _, l := range usrCmds.Listeners["close"] {
-		l.WinListenerFunc(d, data)
-	}
-}
-
-func (d *Daemon) onFocus(event string, con *ipc.Container) {
-	// TODO event enum
-
-	// skip self
-	if con.Name == "sway-yasm" {
-		return
-	}
-
-	space, err := d.conn.GetFocusedWorkspace()
-	if err != nil {
-		d.Logger.Printf("error: %s", err)
-	}
-
-	// update win data
-	id := strconv.Itoa(con.ID)
-	data := types.WindowData{
-		ID:        con.ID,
-		Output:    space.Output,
-		Workspace: space.Name,
-		Title:     con.Name,
-		Rect:      con.Rect,
-		App:       con.WindowProperties.Class,
-	}
-	if con.AppID != nil {
-		data.App = con.AppID.(string)
-	}
-	d.winData[id] = data
-
-	var removed []string
-	d.winFocus, removed = unshiftAndTrim(d.winFocus, id)
-	for _, id := range removed {
-		delete(d.winData, id)
-	}
-
-	// move the pointer
-	if !d.MouseFollowsFocus {
-		return
-	}
-	err = d.MouseToOutput(data.Output)
-	if err != nil {
-		d.Logger.Printf("error: %s", err)
+		parseNode(st, &node, space, output)
 	}
-
-	// run user scripts
-	for _, l := range usrCmds.Listeners[event] {
-		l.WinListenerFunc(d, data)
+	for _, node := range con.FloatingNodes {
+		parseNode(st, &node, space, output)
 	}
 }
 
 func (d *Daemon) FocusedWindow() types.WindowData {
-	if len(d.winFocus) < 1 {
-		return types.WindowData{}
-	}
-	id := d.winFocus[0]
+	var data types.WindowData
+	d.exec(func(st *state) {
+		data = st.focusedWindow()
+	})
 
-	return d.winData[id]
+	return data
 }
 
 func (d *Daemon) PrevWindow() types.WindowData {
-	if len(d.winFocus) < 2 {
-		return types.WindowData{}
-	}
-	id := d.winFocus[1]
+	var data types.WindowData
+	d.exec(func(st *state) {
+		if len(st.winFocus) < 2 {
+			return
+		}
+		data = st.winData[st.winFocus[1]]
+	})
 
-	return d.winData[id]
+	return data
 }
 
 func (d *Daemon) FocusWinID(id int) error {
@@ -398,7 +605,7 @@ func (d *Daemon) FocusWinID(id int) error {
 
 func (d *Daemon) SwayMsgs(msgs []string) error {
 	for _, msg := range msgs {
-		_, err := d.conn.RunSwayCommand(msg)
+		err := d.runCommand(msg)
 		if err != nil {
 			return err
 		}
@@ -413,7 +620,7 @@ func (d *Daemon) SwayMsg(msg string, args ...any) error {
 	if isLog() {
 		d.Logger.Printf("swaymsg %s", cmd)
 	}
-	_, err := d.conn.RunSwayCommand(cmd)
+	err := d.runCommand(cmd)
 	if err != nil {
 		return err
 	}
@@ -422,22 +629,37 @@ func (d *Daemon) SwayMsg(msg string, args ...any) error {
 }
 
 func (d *Daemon) MouseToOutput(output string) error {
-	if d.mouseInOutput == output {
+	var err error
+	d.exec(func(st *state) {
+		err = d.mouseToOutput(st, output)
+	})
+
+	return err
+}
+
+func (d *Daemon) mouseToOutput(st *state, output string) error {
+	if st.mouseInOutput == output {
 		return nil
 	}
 
-	_, err := d.conn.RunSwayCommand(fmt.Sprintf(
+	err := d.runCommand(fmt.Sprintf(
 		`input 0:0:wlr_virtual_pointer_v1 map_to_output "%s"`, output))
 	if err != nil {
 		return err
 	}
-	d.mouseInOutput = output
+	st.mouseInOutput = output
 
 	return nil
 }
 
+// ListWindows returns a copy of all the tracked windows.
 func (d *Daemon) ListWindows() map[string]types.WindowData {
-	return d.winData
+	var data map[string]types.WindowData
+	d.exec(func(st *state) {
+		_, data = st.snapshot()
+	})
+
+	return data
 }
 
 func (d *Daemon) MoveWinToSpaceNum(winID, spaceNum int) error {
@@ -451,21 +673,28 @@ func (d *Daemon) MoveWinToSpaceNum(winID, spaceNum int) error {
 
 func (d *Daemon) MoveWinToSpace(winID int, space string) error {
 	winIDStr := strconv.Itoa(winID)
-	win := d.winData[winIDStr]
-
+	var win types.WindowData
+	d.exec(func(st *state) {
+		win = st.winData[winIDStr]
+	})
 	if win.Workspace == space {
 		// skip already there
 		return nil
 	}
 
+	// commands can't be run within exec, as dry-run simulates them there
 	err := d.SwayMsg("[con_id=%d] move to workspace %s", winID, space)
 	if err != nil {
 		return err
 	}
 
 	// set the new workspace
-	win.Workspace = space
-	d.winData[winIDStr] = win
+	d.exec(func(st *state) {
+		if win, ok := st.winData[winIDStr]; ok {
+			win.Workspace = space
+			st.winData[winIDStr] = win
+		}
+	})
 
 	return nil
 }
@@ -507,7 +736,7 @@ func (d *Daemon) MoveSpaceToOutput(space, output string, focusedWinData types.Wi
 		d.Logger.Printf("error: %s", err)
 		return err
 	}
-	if d.MouseFollowsFocus {
+	if d.mouseFollowsFocus() {
 		err = d.MouseToOutput(focusedWinData.Output)
 		if err != nil {
 			d.Logger.Printf("error: %s", err)
@@ -518,6 +747,15 @@ func (d *Daemon) MoveSpaceToOutput(space, output string, focusedWinData types.Wi
 	return nil
 }
 
+func (d *Daemon) mouseFollowsFocus() bool {
+	var ret bool
+	d.exec(func(st *state) {
+		ret = st.cfg.Daemon.MouseFollowsFocus
+	})
+
+	return ret
+}
+
 func (d *Daemon) WinMatchApp(win types.WindowData, match string) bool {
 	return strings.Contains(strings.ToLower(win.App), strings.ToLower(match))
 }
@@ -542,7 +780,9 @@ func (d *Daemon) HandlerOnNew(func(types.WindowData)) {
 // ///// UTILS
 // ///// ///// /////
 
-func unshiftAndTrim(slice []string, id string) ([]string, []string) {
+// unshiftAndTrim moves the ID to the front and trims the slice to max. Returns
+// the removed IDs.
+func unshiftAndTrim(slice []string, id string, max int) ([]string, []string) {
 	for i, v := range slice {
 		if v == id {
 			slice = append(slice[:i], slice[i+1:]...)
@@ -551,24 +791,13 @@ func unshiftAndTrim(slice []string, id string) ([]string, []string) {
 	}
 	ret := append([]string{id}, slice...)
 	var removed []string
-	if len(ret) > maxTracked {
-		removed = ret[maxTracked:]
-		ret = ret[:maxTracked]
+	if len(ret) > max {
+		removed = ret[max:]
+		ret = ret[:max]
 	}
 	return ret, removed
 }
 
-func maxLen(str string, maxLength int) string {
-	if len(str) > maxLength {
-		if len(str) > 4 {
-			return str[:maxLength-3] + "..."
-		} else {
-			return str[:maxLength]
-		}
-	}
-	return str
-}
-
 func IsLightMode() bool {
 	cmd := exec.Command("gsettings", "get", "org.gnome.desktop.interface", "color-scheme")
 	output, err := cmd.Output()
@@ -0,0 +1,544 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pancsta/gosway/ipc"
+
+	"github.com/pancsta/sway-yasm/internal/config"
+	"github.com/pancsta/sway-yasm/internal/types"
+)
+
+// testTree has 2 outputs:
+//   - DP-1, workspace 1: firefox (11), a split (12) with foot (13) and kitty
+//     (14), a floating mpv (15)
+//   - HDMI-A-1, workspace 2: a picker (21), code (22)
+func testTree() (*ipc.Tree, []*ipc.Workspace) {
+	win := func(id int64, app string) ipc.Node {
+		return ipc.Node{ID: id, Name: app + " title", Type: "con",
+			AppID: app}
+	}
+	split := ipc.Node{ID: 12, Type: "con", Layout: "splitv",
+		Nodes: []ipc.Node{win(13, "foot"), win(14, "kitty")}}
+	mpv := win(15, "mpv")
+	mpv.Type = "floating_con"
+
+	tree := &ipc.Tree{Nodes: []ipc.Node{
+		{ID: 1, Name: "DP-1", Type: "output", Nodes: []ipc.Node{{
+			ID: 10, Name: "1", Type: "workspace", Layout: "splith",
+			Nodes:         []ipc.Node{win(11, "firefox"), split},
+			FloatingNodes: []ipc.Node{mpv},
+		}}},
+		{ID: 2, Name: "HDMI-A-1", Type: "output", Nodes: []ipc.Node{{
+			ID: 20, Name: "2", Type: "workspace", Layout: "splith",
+			Nodes: []ipc.Node{win(21, config.WindowID), win(22, "code")},
+		}}},
+	}}
+	spaces := []*ipc.Workspace{
+		{ID: 10, Name: "1", Output: "DP-1", Focused: true, Visible: true},
+		{ID: 20, Name: "2", Output: "HDMI-A-1", Visible: true},
+	}
+
+	return tree, spaces
+}
+
+// newMemDaemon returns a daemon connected to a memCompositor, handling its
+// events until the test ends.
+func newMemDaemon(
+	t *testing.T, cfg *config.Config, tree *ipc.Tree, spaces []*ipc.Workspace,
+) (*Daemon, *memCompositor) {
+	t.Helper()
+
+	mem := newMemCompositor(tree, spaces)
+	d := &Daemon{
+		Logger: log.New(io.Discard, "", 0),
+		dial:   func() (compositor, error) { return mem, nil },
+		state:  newState(cfg),
+		queue:  make(chan func()),
+	}
+	go d.loop()
+
+	s, err := d.connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go d.listen(s)
+	t.Cleanup(func() {
+		s.Errors <- io.EOF
+	})
+
+	return d, mem
+}
+
+// testConfig returns the defaults, without touching sway's config.
+func testConfig() *config.Config {
+	cfg := config.Default()
+	cfg.Daemon.Autoconfig = false
+
+	return cfg
+}
+
+// emitSync emits the event and waits until it's handled, by emitting another
+// one after it.
+func emitSync(mem *memCompositor, e swayEvent) {
+	mem.emit(e)
+	mem.emit(swayEvent{Type: eventMode, Change: "default"})
+}
+
+func winFocus(d *Daemon) []string {
+	var ret []string
+	d.exec(func(st *state) {
+		ret = slices.Clone(st.winFocus)
+	})
+
+	return ret
+}
+
+func TestUnshiftAndTrim(t *testing.T) {
+	tests := []struct {
+		name    string
+		ids     []string
+		id      string
+		max     int
+		want    []string
+		removed []string
+	}{
+		{"empty", nil, "1", 3, []string{"1"}, nil},
+		{"new", []string{"1", "2"}, "3", 3, []string{"3", "1", "2"}, nil},
+		{"existing", []string{"1", "2", "3"}, "3", 3,
+			[]string{"3", "1", "2"}, nil},
+		{"first", []string{"1", "2"}, "1", 3, []string{"1", "2"}, nil},
+		{"trim", []string{"1", "2", "3"}, "4", 3, []string{"4", "1", "2"},
+			[]string{"3"}},
+		{"trim many", []string{"1", "2", "3", "4"}, "5", 2,
+			[]string{"5", "1"}, []string{"2", "3", "4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, removed := unshiftAndTrim(slices.Clone(tt.ids), tt.id, tt.max)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if !slices.Equal(removed, tt.removed) {
+				t.Errorf("removed %v, want %v", removed, tt.removed)
+			}
+		})
+	}
+}
+
+func TestFindPathToRoot(t *testing.T) {
+	tree, _ := testTree()
+	space := &tree.Nodes[0].Nodes[0]
+
+	tests := []struct {
+		name  string
+		id    int64
+		found bool
+		path  []int64
+	}{
+		{"workspace", 10, true, []int64{10}},
+		{"tiled", 11, true, []int64{11, 10}},
+		{"nested", 14, true, []int64{14, 12, 10}},
+		{"floating", 15, true, []int64{15, 10}},
+		{"other workspace", 22, false, nil},
+		{"missing", 99, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found, path := findPathToRoot(space, tt.id, nil)
+			if found != tt.found {
+				t.Fatalf("found %v, want %v", found, tt.found)
+			}
+			var ids []int64
+			for _, node := range path {
+				ids = append(ids, node.ID)
+			}
+			if !slices.Equal(ids, tt.path) {
+				t.Errorf("path %v, want %v", ids, tt.path)
+			}
+		})
+	}
+}
+
+func TestRebuild(t *testing.T) {
+	tests := []struct {
+		name      string
+		prevFocus []string
+		max       int
+		want      []string
+	}{
+		// the last parsed window is the most recent one
+		{"tree order", nil, 10, []string{"22", "15", "14", "13", "11"}},
+		{"previous MRU first", []string{"13", "99", "11"}, 10,
+			[]string{"13", "11", "22", "15", "14"}},
+		{"trimmed", nil, 3, []string{"22", "15", "14"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.Daemon.MaxTracked = tt.max
+			st := newState(cfg)
+			st.winFocus = tt.prevFocus
+			tree, spaces := testTree()
+
+			rebuild(st, tree, spaces)
+			if !slices.Equal(st.winFocus, tt.want) {
+				t.Errorf("winFocus %v, want %v", st.winFocus, tt.want)
+			}
+			if st.focusedSpace != "10" {
+				t.Errorf("focused workspace %s, want 10", st.focusedSpace)
+			}
+		})
+	}
+}
+
+func TestParseNode(t *testing.T) {
+	tree, spaces := testTree()
+	st := newState(testConfig())
+	rebuild(st, tree, spaces)
+
+	tests := []struct {
+		id       string
+		tracked  bool
+		app      string
+		space    string
+		output   string
+		floating bool
+	}{
+		{"11", true, "firefox", "1", "DP-1", false},
+		{"12", false, "", "", "", false},
+		{"14", true, "kitty", "1", "DP-1", false},
+		{"15", true, "mpv", "1", "DP-1", true},
+		{"21", false, "", "", "", false},
+		{"22", true, "code", "2", "HDMI-A-1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			data, ok := st.winData[tt.id]
+			if ok != tt.tracked {
+				t.Fatalf("tracked %v, want %v", ok, tt.tracked)
+			}
+			if !ok {
+				return
+			}
+			if data.App != tt.app || data.Workspace != tt.space ||
+				data.Output != tt.output || data.Floating != tt.floating {
+
+				t.Errorf("got %+v", data)
+			}
+		})
+	}
+}
+
+func TestMRUEvents(t *testing.T) {
+	focus := func(id int) swayEvent {
+		return swayEvent{Type: eventWindow, Change: "focus",
+			Container: ipc.Container{ID: id, AppID: "app"}}
+	}
+	closed := func(id int) swayEvent {
+		return swayEvent{Type: eventWindow, Change: "close",
+			Container: ipc.Container{ID: id}}
+	}
+
+	tests := []struct {
+		name   string
+		max    int
+		events []swayEvent
+		want   []string
+	}{
+		{"focus", 10, []swayEvent{focus(13)},
+			[]string{"13", "22", "15", "14", "11"}},
+		{"focus twice", 10, []swayEvent{focus(13), focus(11)},
+			[]string{"11", "13", "22", "15", "14"}},
+		{"close", 10, []swayEvent{focus(13), closed(13)},
+			[]string{"22", "15", "14", "11"}},
+		{"picker", 10, []swayEvent{{Type: eventWindow, Change: "focus",
+			Container: ipc.Container{ID: 21, AppID: config.WindowID}}},
+			[]string{"22", "15", "14", "13", "11"}},
+		{"trimmed", 2, []swayEvent{focus(11), focus(13)},
+			[]string{"13", "11"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.Daemon.MaxTracked = tt.max
+			tree, spaces := testTree()
+			d, mem := newMemDaemon(t, cfg, tree, spaces)
+
+			for _, e := range tt.events {
+				emitSync(mem, e)
+			}
+			if got := winFocus(d); !slices.Equal(got, tt.want) {
+				t.Errorf("winFocus %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMRUNewWindow(t *testing.T) {
+	tree, spaces := testTree()
+	d, mem := newMemDaemon(t, testConfig(), tree, spaces)
+
+	// sway adds the window to the tree before the event
+	tree, spaces = testTree()
+	space := &tree.Nodes[1].Nodes[0]
+	space.Nodes = append(space.Nodes, ipc.Node{ID: 23, Type: "con",
+		AppID: "foot"})
+	mem.setTree(tree, spaces)
+	emitSync(mem, swayEvent{Type: eventWindow, Change: "new",
+		Container: ipc.Container{ID: 23, AppID: "foot"}})
+
+	want := []string{"23", "22", "15", "14", "13", "11"}
+	if got := winFocus(d); !slices.Equal(got, want) {
+		t.Errorf("winFocus %v, want %v", got, want)
+	}
+	win := d.ListWindows()["23"]
+	if win.Workspace != "2" || win.Output != "HDMI-A-1" {
+		t.Errorf("got %+v", win)
+	}
+}
+
+func TestMemCommands(t *testing.T) {
+	tree, spaces := testTree()
+	d, mem := newMemDaemon(t, testConfig(), tree, spaces)
+
+	if err := d.FocusWinID(14); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.MoveWinToSpace(22, "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	got := mem.Commands()
+	want := []string{`[con_id=14] focus`, `[con_id=22] move to workspace 1`}
+	if len(got) < 2 || !slices.Equal(got[:2], want) {
+		t.Errorf("commands %q, want %q", got, want)
+	}
+}
+
+func TestReplayConfig(t *testing.T) {
+	tree, spaces := testTree()
+	focus := &swayEvent{Type: eventWindow, Change: "focus",
+		Container: ipc.Container{ID: 13, AppID: "foot"}}
+
+	tests := []struct {
+		name   string
+		config string
+		want   []string
+	}{
+		{"defaults", "", []string{"13", "22", "15", "14", "11"}},
+		{"recorded", "daemon:\n  max_tracked: 2\n", []string{"13", "22"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			enc := json.NewEncoder(&buf)
+			enc.Encode(record{Kind: recordConnect, Tree: tree,
+				Workspaces: spaces, Config: tt.config})
+			enc.Encode(record{Kind: recordEvent, EventType: "window",
+				Event: focus})
+
+			ret, err := Replay(&buf, log.New(io.Discard, "", 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(ret.WinFocus, tt.want) {
+				t.Errorf("winFocus %v, want %v", ret.WinFocus, tt.want)
+			}
+		})
+	}
+}
+
+func TestPickerShowWarm(t *testing.T) {
+	tests := []struct {
+		name string
+		// busy is a warm picker not taking the sessions
+		busy bool
+		want PickerShowReply
+	}{
+		{"warm", false, PickerShowReply{Warm: true, Session: 1}},
+		{"busy", true, PickerShowReply{Open: true, Session: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree, spaces := testTree()
+			d, mem := newMemDaemon(t, testConfig(), tree, spaces)
+			warm := &warmPicker{conID: 21, pid: os.Getpid(), backend: "fzf",
+				show: make(chan PickerNextReply, 1)}
+			if tt.busy {
+				warm.show <- PickerNextReply{Picker: "switcher", Session: 99}
+			}
+			d.exec(func(st *state) {
+				st.warm = warm
+			})
+
+			var reply PickerShowReply
+			err := d.RemotePickerShow(PickerShowArgs{Picker: "switcher",
+				Backend: "fzf", Term: true, PID: os.Getpid()}, &reply)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reply != tt.want {
+				t.Fatalf("reply %+v, want %+v", reply, tt.want)
+			}
+
+			var s pickerSession
+			d.exec(func(st *state) {
+				s = *st.pickers[reply.Session]
+			})
+			if s.warm != reply.Warm {
+				t.Errorf("session warm %v, want %v", s.warm, reply.Warm)
+			}
+			if tt.busy {
+				return
+			}
+			if next := <-warm.show; next.Session != reply.Session {
+				t.Errorf("next session %d, want %d", next.Session,
+					reply.Session)
+			}
+			want := `[con_id=21] scratchpad show, move position center`
+			if cmds := mem.Commands(); !slices.Contains(cmds, want) {
+				t.Errorf("commands %q, want %q", cmds, want)
+			}
+		})
+	}
+}
+
+func TestPickerNextPoll(t *testing.T) {
+	tree, spaces := testTree()
+	d, _ := newMemDaemon(t, testConfig(), tree, spaces)
+	warm := &warmPicker{conID: 21, pid: os.Getpid(), backend: "fzf",
+		show: make(chan PickerNextReply, 1)}
+	d.exec(func(st *state) {
+		// longer than the client's
+		st.cfg.Daemon.RPCTimeout = time.Minute
+		st.warm = warm
+	})
+	args := PickerServeArgs{PID: os.Getpid(), Backend: "fzf",
+		Poll: 10 * time.Millisecond}
+
+	// the client's poll, not the daemon's rpc_timeout
+	start := time.Now()
+	var reply PickerNextReply
+	if err := d.RemotePickerNext(args, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Session != 0 {
+		t.Errorf("session %d, want none", reply.Session)
+	}
+	if since := time.Since(start); since > time.Second {
+		t.Errorf("polled for %s", since)
+	}
+
+	warm.show <- PickerNextReply{Picker: "switcher", Session: 3}
+	if err := d.RemotePickerNext(args, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Session != 3 {
+		t.Errorf("session %d, want 3", reply.Session)
+	}
+}
+
+func TestEventJSON(t *testing.T) {
+	e := Event{Type: EventWindowFocus,
+		Window:    &types.WindowData{ID: 13, App: "foot", Workspace: "1"},
+		Workspace: &types.SpaceData{ID: 3, Name: "1", Output: "DP-1"}}
+	data, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields struct {
+		Window    map[string]any `json:"window"`
+		Workspace map[string]any `json:"workspace"`
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+
+	// snake_case, as the event's own fields
+	for obj, keys := range map[*map[string]any][]string{
+		&fields.Window:    {"id", "app", "workspace", "output", "rect"},
+		&fields.Workspace: {"id", "name", "output", "visible"},
+	} {
+		for _, key := range keys {
+			if _, ok := (*obj)[key]; !ok {
+				t.Errorf("no %q in %s", key, data)
+			}
+		}
+	}
+}
+
+func TestJSONRPC(t *testing.T) {
+	tree, spaces := testTree()
+	cfg := testConfig()
+	cfg.Daemon.JSONRPC = true
+	d, _ := newMemDaemon(t, cfg, tree, spaces)
+	h := newJSONHandler(d)
+
+	// the picker protocol stays internal
+	for name := range h.methods {
+		if strings.HasPrefix(name, "Picker") {
+			t.Errorf("method %s exposed", name)
+		}
+	}
+	for _, name := range jsonPublic {
+		if _, ok := h.methods[name]; !ok {
+			t.Errorf("method %s missing", name)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		id     string
+		code   int
+	}{
+		{"call", `{"jsonrpc": "2.0", "id": 1, "method": "Spaces"}`,
+			http.StatusOK, "1", 0},
+		{"null id", `{"jsonrpc": "2.0", "id": null, "method": "Spaces"}`,
+			http.StatusOK, "null", 0},
+		{"notification", `{"jsonrpc": "2.0", "method": "Spaces"}`,
+			http.StatusNoContent, "", 0},
+		{"internal", `{"jsonrpc": "2.0", "id": 1, "method": "PickerNext"}`,
+			http.StatusOK, "1", jsonNoMethod},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/",
+				strings.NewReader(tt.body)))
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d", rec.Code, tt.status)
+			}
+			if tt.status == http.StatusNoContent {
+				return
+			}
+
+			var resp jsonResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if string(resp.ID) != tt.id {
+				t.Errorf("id %s, want %s", resp.ID, tt.id)
+			}
+			code := 0
+			if resp.Error != nil {
+				code = resp.Error.Code
+			}
+			if code != tt.code {
+				t.Errorf("code %d, want %d", code, tt.code)
+			}
+		})
+	}
+}
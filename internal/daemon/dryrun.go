@@ -0,0 +1,307 @@
+package daemon
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pancsta/gosway/ipc"
+
+	"github.com/pancsta/sway-yasm/internal/swaycmd"
+	"github.com/pancsta/sway-yasm/internal/types"
+)
+
+// commandLog collects the commands of a dry-run daemon.
+type commandLog struct {
+	mx       sync.Mutex
+	commands []string
+}
+
+func (l *commandLog) add(cmd string) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	l.commands = append(l.commands, cmd)
+}
+
+func (l *commandLog) len() int {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	return len(l.commands)
+}
+
+// since returns the commands added after the first n.
+func (l *commandLog) since(n int) []string {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	if n > len(l.commands) {
+		return nil
+	}
+
+	return slices.Clone(l.commands[n:])
+}
+
+// dryRunCommand records the command and simulates it on the state, instead of
+// running it. Only the commands changing the state go through exec, so
+// mouseToOutput stays callable within exec.
+func (d *Daemon) dryRunCommand(cmd string) error {
+	d.dryRun.add(cmd)
+	d.Logger.Printf("dry-run: %s", cmd)
+
+	cmds := parseCommands(cmd)
+	if !slices.ContainsFunc(cmds, simulated) {
+		return nil
+	}
+	d.exec(func(st *state) {
+		for _, c := range cmds {
+			simulate(st, c)
+		}
+	})
+
+	return nil
+}
+
+// dryRunCopy returns a dry-run daemon with a copy of the state, which requests
+// trees via this daemon. Close its queue once done.
+func (d *Daemon) dryRunCopy() *Daemon {
+	cp := &Daemon{
+		Logger: d.Logger,
+		conn:   &parentCompositor{d: d},
+		DryRun: true,
+		dryRun: &commandLog{},
+		queue:  make(chan func()),
+	}
+	d.exec(func(st *state) {
+		cp.state = st.clone()
+	})
+	go cp.loop()
+
+	return cp
+}
+
+// parentCompositor passes the requests of a dry-run copy to the parent
+// daemon's connection. It never runs commands.
+type parentCompositor struct {
+	d *Daemon
+}
+
+var _ compositor = &parentCompositor{}
+
+var errReadOnly = errors.New("read-only compositor")
+
+func (c *parentCompositor) GetTree() (*ipc.Tree, error) {
+	return c.d.getTree()
+}
+
+func (c *parentCompositor) GetWorkspaces() ([]*ipc.Workspace, error) {
+	return c.d.getWorkspaces()
+}
+
+func (c *parentCompositor) RunCommand(string) error {
+	return errReadOnly
+}
+
+func (c *parentCompositor) Subscribe([]string) (*subscription, error) {
+	return nil, errReadOnly
+}
+
+func (c *parentCompositor) Close() error {
+	return nil
+}
+
+// ///// ///// /////
+// ///// SIMULATION
+// ///// ///// /////
+
+// swayCommand is a single command, eg "[con_id=1] focus".
+type swayCommand struct {
+	// conID is the con_id criteria, 0 means the focused window
+	conID int
+	args  []string
+}
+
+// parseCommands splits the payload into commands. Criteria apply until the
+// next ";", as in sway.
+func parseCommands(payload string) []swayCommand {
+	var ret []swayCommand
+	for _, group := range swaycmd.Split(payload, ';') {
+		conID := 0
+		for _, cmd := range swaycmd.Split(group, ',') {
+			cmd = strings.TrimSpace(cmd)
+			if strings.HasPrefix(cmd, "[") {
+				end := strings.Index(cmd, "]")
+				if end < 0 {
+					continue
+				}
+				key, val, _ := strings.Cut(cmd[1:end], "=")
+				if strings.TrimSpace(key) == "con_id" {
+					conID, _ = strconv.Atoi(strings.Trim(val, `"' `))
+				}
+				cmd = cmd[end+1:]
+			}
+			if args := swaycmd.Fields(cmd); len(args) > 0 {
+				ret = append(ret, swayCommand{conID: conID, args: args})
+			}
+		}
+	}
+
+	return ret
+}
+
+// simulated returns true for commands changing the state.
+func simulated(c swayCommand) bool {
+	switch c.args[0] {
+	case "focus", "workspace", "move":
+		return true
+	}
+
+	return false
+}
+
+// simulate applies the effects of the command on the state, as if sway sent
+// the events. Handles "focus", "workspace NAME", "move to workspace NAME" and
+// "move workspace to output NAME".
+func simulate(st *state, c swayCommand) {
+	win := st.focusedWindow()
+	if c.conID != 0 {
+		win = st.winData[strconv.Itoa(c.conID)]
+	}
+	args := c.args
+
+	switch args[0] {
+
+	case "focus":
+		if len(args) == 1 && win.ID != 0 {
+			st.simFocusWin(win)
+		}
+
+	case "workspace":
+		name := strings.Join(swaycmd.Skip(args[1:], "--no-auto-back-and-forth",
+			"number"), " ")
+		if name != "" {
+			st.simFocusSpace(st.simSpace(name), true)
+		}
+
+	case "move":
+		args = swaycmd.Skip(args[1:], "container", "window")
+		if len(args) > 1 && args[0] == "workspace" &&
+			(args[1] == "to" || args[1] == "output") {
+
+			args = swaycmd.Skip(args[1:], "to", "output")
+			if len(args) == 1 {
+				st.simMoveSpace(args[0])
+			}
+			return
+		}
+		args = swaycmd.Skip(args, "to")
+		if len(args) < 2 || args[0] != "workspace" || win.ID == 0 {
+			return
+		}
+		name := strings.Join(swaycmd.Skip(args[1:], "--no-auto-back-and-forth",
+			"number"), " ")
+		space := st.spaces[st.simSpace(name)]
+		win.Workspace = space.Name
+		win.Output = space.Output
+		st.winData[strconv.Itoa(win.ID)] = win
+	}
+}
+
+// simSpace returns the ID of the workspace, creating it on the focused output
+// if needed. Created workspaces get negative IDs.
+func (s *state) simSpace(name string) string {
+	for id, space := range s.spaces {
+		if space.Name == name {
+			return id
+		}
+	}
+
+	num := -1
+	for {
+		if _, ok := s.spaces[strconv.Itoa(num)]; !ok {
+			break
+		}
+		num--
+	}
+	id := strconv.Itoa(num)
+	s.spaces[id] = types.SpaceData{
+		ID:     num,
+		Name:   name,
+		Output: s.spaces[s.focusedSpace].Output,
+	}
+	s.spaceFocus = append(s.spaceFocus, id)
+
+	return id
+}
+
+// simFocusSpace focuses the workspace and optionally its most recent window.
+func (s *state) simFocusSpace(id string, focusWin bool) {
+	space := s.spaces[id]
+	for otherID, other := range s.spaces {
+		if other.Output == space.Output && other.Visible {
+			other.Visible = false
+			s.spaces[otherID] = other
+		}
+	}
+	space.Visible = true
+	s.spaces[id] = space
+	s.focusedSpace = id
+	s.spaceFocus, _ = s.unshift(s.spaceFocus, id)
+
+	if !focusWin {
+		return
+	}
+	for _, winID := range s.winFocus {
+		if s.winData[winID].Workspace == space.Name {
+			s.simFocusWin(s.winData[winID])
+			return
+		}
+	}
+}
+
+// simFocusWin moves the window to the top of the MRU list.
+func (s *state) simFocusWin(win types.WindowData) {
+	for id, space := range s.spaces {
+		if space.Name == win.Workspace && id != s.focusedSpace {
+			s.simFocusSpace(id, false)
+			break
+		}
+	}
+
+	var removed []string
+	s.winFocus, removed = s.unshift(s.winFocus, strconv.Itoa(win.ID))
+	for _, id := range removed {
+		delete(s.winData, id)
+	}
+}
+
+// simMoveSpace moves the focused workspace, with its windows, to the output.
+func (s *state) simMoveSpace(output string) {
+	space, ok := s.spaces[s.focusedSpace]
+	if !ok {
+		return
+	}
+	prevOutput := space.Output
+	space.Output = output
+	s.spaces[s.focusedSpace] = space
+	s.simFocusSpace(s.focusedSpace, false)
+
+	// the previous output shows its most recent workspace
+	for _, id := range s.spaceFocus {
+		if other := s.spaces[id]; other.Output == prevOutput {
+			other.Visible = true
+			s.spaces[id] = other
+			break
+		}
+	}
+
+	for id, win := range s.winData {
+		if win.Workspace == space.Name {
+			win.Output = output
+			s.winData[id] = win
+		}
+	}
+}
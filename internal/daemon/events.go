@@ -0,0 +1,284 @@
+package daemon
+
+import (
+	"strconv"
+
+	"github.com/pancsta/gosway/ipc"
+	"github.com/samber/lo"
+
+	"github.com/pancsta/sway-yasm/internal/config"
+	"github.com/pancsta/sway-yasm/internal/types"
+)
+
+// ///// ///// /////
+// ///// SWAY EVENTS
+// ///// ///// /////
+
+// onEvent updates the state according to a sway event. Returns the window
+// data for the user listeners, if any.
+func (d *Daemon) onEvent(st *state, e *swayEvent) (types.WindowData, bool) {
+	switch e.Type {
+
+	case eventWindow:
+		return d.onWindow(st, e.Change, &e.Container)
+
+	case eventWorkspace:
+		d.onWorkspace(st, e)
+
+	case eventOutput:
+		// workspaces could have been moved between outputs
+		d.refresh(st)
+
+	case eventMode:
+		st.mode = e.Change
+	}
+
+	return types.WindowData{}, false
+}
+
+func (d *Daemon) onWindow(
+	st *state, change string, con *ipc.Container,
+) (types.WindowData, bool) {
+	id := strconv.Itoa(con.ID)
+
+	switch change {
+
+	case "focus":
+		return d.onFocus(st, con)
+
+	case "new":
+		return d.onNew(st, con)
+
+	case "close":
+		return d.onClose(st, con)
+
+	case "move":
+		// the container can end up anywhere, ask sway
+		data, ok := st.winData[id]
+		if !ok {
+			return data, false
+		}
+		space, output, err := d.findWinSpace(con.ID)
+		if err != nil {
+			d.Logger.Printf("error: %s", err)
+			return data, false
+		}
+		data.Workspace = space
+		data.Output = output
+		data.Rect = con.Rect
+		st.winData[id] = data
+
+		return data, true
+
+	case "title", "floating", "fullscreen_mode", "urgent":
+		data, ok := st.winData[id]
+		if !ok {
+			return data, false
+		}
+		data.Title = con.Name
+		data.Rect = con.Rect
+		data.Floating = con.Type == "floating_con"
+		data.Fullscreen = con.FullscreenMode != 0
+		data.Urgent = con.Urgent
+		st.winData[id] = data
+
+		return data, true
+	}
+
+	return types.WindowData{}, false
+}
+
+// onClose removes the window from the state and returns its last data.
+func (d *Daemon) onClose(st *state, c *ipc.Container) (types.WindowData, bool) {
+	id := strconv.Itoa(c.ID)
+
+	// remove ID from winFocus
+	st.winFocus = lo.Without(st.winFocus, id)
+
+	// remove from winData
+	data, ok := st.winData[id]
+	delete(st.winData, id)
+
+	return data, ok
+}
+
+// isPicker returns true for the windows of the pickers, matched by the title
+// or app_id, as not all terminals can set both.
+func isPicker(con *ipc.Container) bool {
+	appID, _ := con.AppID.(string)
+
+	return con.Name == config.WindowID || appID == config.WindowID
+}
+
+// onFocus updates the window's data and moves it to the top of the MRU list.
+// The window is on the focused workspace, as sway sends the workspace event
+// first.
+func (d *Daemon) onFocus(st *state, con *ipc.Container) (types.WindowData, bool) {
+	if isPicker(con) {
+		return types.WindowData{}, false
+	}
+
+	space := st.spaces[st.focusedSpace]
+	data := winFromContainer(con, space.Name, space.Output)
+	d.track(st, data)
+
+	return data, true
+}
+
+// onNew tracks a new window. New windows can be assigned to any workspace.
+func (d *Daemon) onNew(st *state, con *ipc.Container) (types.WindowData, bool) {
+	if isPicker(con) {
+		return types.WindowData{}, false
+	}
+
+	space, output, err := d.findWinSpace(con.ID)
+	if err != nil {
+		d.Logger.Printf("error: %s", err)
+		return types.WindowData{}, false
+	}
+	data := winFromContainer(con, space, output)
+	d.track(st, data)
+
+	return data, true
+}
+
+// track saves the window's data and moves it to the top of the MRU list.
+func (d *Daemon) track(st *state, data types.WindowData) {
+	id := strconv.Itoa(data.ID)
+	st.winData[id] = data
+
+	var removed []string
+	st.winFocus, removed = st.unshift(st.winFocus, id)
+	for _, id := range removed {
+		delete(st.winData, id)
+	}
+
+	// move the pointer
+	if st.cfg.Daemon.MouseFollowsFocus {
+		err := d.mouseToOutput(st, data.Output)
+		if err != nil {
+			d.Logger.Printf("error: %s", err)
+		}
+	}
+}
+
+func (d *Daemon) onWorkspace(st *state, e *swayEvent) {
+	if e.Current == nil {
+		return
+	}
+	id := strconv.Itoa(e.Current.ID)
+	space := types.SpaceData{
+		ID:     e.Current.ID,
+		Name:   e.Current.Name,
+		Output: e.Current.Output,
+	}
+
+	switch e.Change {
+
+	case "focus":
+		// the focused workspace replaces the visible one on its output
+		for otherID, other := range st.spaces {
+			if other.Output == space.Output && other.Visible {
+				other.Visible = false
+				st.spaces[otherID] = other
+			}
+		}
+		space.Visible = true
+		st.spaces[id] = space
+		st.focusedSpace = id
+		st.spaceFocus, _ = st.unshift(st.spaceFocus, id)
+
+	case "init":
+		st.spaces[id] = space
+		if !lo.Contains(st.spaceFocus, id) {
+			st.spaceFocus = append(st.spaceFocus, id)
+		}
+
+	case "empty":
+		delete(st.spaces, id)
+		st.spaceFocus = lo.Without(st.spaceFocus, id)
+
+	case "move":
+		// visibility changes on both outputs
+		d.refresh(st)
+
+	case "rename":
+		// update the windows on the workspace
+		prev, ok := st.spaces[id]
+		space.Visible = prev.Visible
+		st.spaces[id] = space
+		if !ok {
+			return
+		}
+		for winID, win := range st.winData {
+			if win.Workspace != prev.Name {
+				continue
+			}
+			win.Workspace = space.Name
+			win.Output = space.Output
+			st.winData[winID] = win
+		}
+
+	case "reload":
+		d.refresh(st)
+	}
+}
+
+// refresh re-reads the whole state from sway, while keeping the MRU order.
+func (d *Daemon) refresh(st *state) {
+	tree, err := d.getTree()
+	if err != nil {
+		d.Logger.Printf("error: %s", err)
+		return
+	}
+	spaces, err := d.getWorkspaces()
+	if err != nil {
+		d.Logger.Printf("error: %s", err)
+		return
+	}
+	d.record(record{Kind: recordRefresh, Tree: tree, Workspaces: spaces})
+
+	rebuild(st, tree, spaces)
+}
+
+// findWinSpace returns the workspace and output names of the passed window.
+func (d *Daemon) findWinSpace(id int) (string, string, error) {
+	tree, err := d.getTree()
+	if err != nil {
+		return "", "", err
+	}
+	d.record(record{Kind: recordTree, Tree: tree})
+
+	for i := range tree.Nodes {
+		output := &tree.Nodes[i]
+		for ii := range output.Nodes {
+			workspace := &output.Nodes[ii]
+			found, _ := findPathToRoot(workspace, int64(id), nil)
+			if found {
+				return workspace.Name, output.Name, nil
+			}
+		}
+	}
+
+	return "", "", nil
+}
+
+// winFromContainer converts an event's container into the window data.
+func winFromContainer(con *ipc.Container, space, output string) types.WindowData {
+	data := types.WindowData{
+		ID:         con.ID,
+		Output:     output,
+		Workspace:  space,
+		Title:      con.Name,
+		Rect:       con.Rect,
+		App:        con.WindowProperties.Class,
+		Floating:   con.Type == "floating_con",
+		Fullscreen: con.FullscreenMode != 0,
+		Urgent:     con.Urgent,
+	}
+	if appID, ok := con.AppID.(string); ok {
+		data.App = appID
+	}
+
+	return data
+}
@@ -0,0 +1,329 @@
+package daemon_test
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pancsta/sway-yasm/internal/daemon"
+	"github.com/pancsta/sway-yasm/internal/swaytest"
+)
+
+// startDaemon runs the daemon against a swaytest server, until the test ends.
+// PATH is watched for executables, empty means a new empty dir.
+func startDaemon(t *testing.T, srv *swaytest.Server, path string) {
+	t.Helper()
+
+	// isolate the sockets, config and the watched PATH
+	if path == "" {
+		path = t.TempDir()
+	}
+	t.Setenv("SWAYSOCK", srv.Path)
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("PATH", path)
+
+	d := &daemon.Daemon{Logger: log.New(io.Discard, "", 0)}
+	done := make(chan struct{})
+	go func() {
+		d.Start()
+		close(done)
+	}()
+	t.Cleanup(func() {
+		if err := srv.Shutdown(); err != nil {
+			t.Error(err)
+		}
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Error("daemon didn't stop")
+		}
+	})
+}
+
+// eventually retries fn until it returns nil, or fails the test.
+func eventually(t *testing.T, fn func() error) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := fn()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// windowIDs lists the windows in the MRU order, via the switcher's RPC.
+func windowIDs(client *rpc.Client, args daemon.WindowsArgs) ([]int, error) {
+	var reply daemon.WindowsReply
+	err := client.Call("Daemon.RemoteWindows", args, &reply)
+
+	var ids []int
+	for _, win := range reply.Windows {
+		ids = append(ids, win.ID)
+	}
+
+	return ids, err
+}
+
+func expectWindows(
+	client *rpc.Client, args daemon.WindowsArgs, want []int,
+) func() error {
+	return func() error {
+		ids, err := windowIDs(client, args)
+		if err != nil {
+			return err
+		}
+		if !slices.Equal(ids, want) {
+			return fmt.Errorf("windows %v, want %v", ids, want)
+		}
+
+		return nil
+	}
+}
+
+func TestDaemon(t *testing.T) {
+	srv, err := swaytest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// closed after the daemon stops
+	t.Cleanup(func() { srv.Close() })
+
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	addWin := func(space, app string) int {
+		t.Helper()
+		id, err := srv.AddWindow(space, app, app+" title")
+		must(err)
+		return id
+	}
+	must(srv.AddOutput("DP-1"))
+	must(srv.AddWorkspace("DP-1", "1"))
+	must(srv.AddWorkspace("DP-1", "2"))
+	foot := addWin("1", "foot")
+	firefox := addWin("1", "firefox")
+	code := addWin("2", "code")
+
+	startDaemon(t, srv, "")
+	client := dialDaemon(t)
+	eventually(t, expectWindows(client, daemon.WindowsArgs{},
+		[]int{code, firefox, foot}))
+
+	// focus changes the MRU
+	must(srv.Focus(foot))
+	eventually(t, expectWindows(client, daemon.WindowsArgs{},
+		[]int{foot, code, firefox}))
+
+	// move code to the focused workspace
+	must(client.Call("Daemon.RemoteMoveWinToSpace",
+		daemon.WinArgs{ID: code}, &daemon.Empty{}))
+	eventually(t, expectWindows(client,
+		daemon.WindowsArgs{CurrentSpaceOnly: true},
+		[]int{code, foot, firefox}))
+	if win, _ := srv.Window(code); win.Workspace != "1" {
+		t.Errorf("workspace %s, want 1", win.Workspace)
+	}
+	if srv.FocusedWindow() != code {
+		t.Errorf("focused %d, want %d", srv.FocusedWindow(), code)
+	}
+
+	// a user command on the focused window
+	var reply daemon.UsrCmdReply
+	must(client.Call("Daemon.RemoteUsrCmd",
+		daemon.UsrCmdArgs{Name: "titlebar-toggle"}, &reply))
+	if win, _ := srv.Window(code); win.Border != "none" {
+		t.Errorf("border %s, want none", win.Border)
+	}
+
+	// the dry run doesn't reach sway
+	reply = daemon.UsrCmdReply{}
+	must(client.Call("Daemon.RemoteUsrCmd",
+		daemon.UsrCmdArgs{Name: "titlebar-toggle", DryRun: true}, &reply))
+	if !slices.Equal(reply.Commands, []string{"border normal"}) {
+		t.Errorf("dry run commands %q", reply.Commands)
+	}
+
+	want := []string{
+		fmt.Sprintf(`[con_id="%d"] move workspace 1`, code),
+		fmt.Sprintf(`[con_id=%d] focus`, code),
+		`border none`,
+	}
+	cmds := srv.Commands()
+	i := slices.Index(cmds, want[0])
+	if i < 0 || len(cmds) < i+len(want) ||
+		!slices.Equal(cmds[i:i+len(want)], want) {
+
+		t.Errorf("commands %q, want %q", cmds, want)
+	}
+
+	// disabling mouse_follows_focus releases the pointer
+	for _, val := range []string{"true", "false"} {
+		must(client.Call("Daemon.RemoteSetConfig", daemon.ConfigArgs{
+			Key: "daemon.mouse_follows_focus", Value: val,
+		}, &daemon.Empty{}))
+	}
+	cmds = srv.Commands()
+	if last := cmds[len(cmds)-1]; last !=
+		`input 0:0:wlr_virtual_pointer_v1 map_to_output "*"` {
+
+		t.Errorf("last command %q", last)
+	}
+}
+
+// dialDaemon opens an RPC connection, as a client process would. The global
+// client of RemoteCall could outlive the test's daemon.
+func dialDaemon(t *testing.T) *rpc.Client {
+	t.Helper()
+
+	var client *rpc.Client
+	eventually(t, func() error {
+		socks, _ := filepath.Glob(filepath.Join(os.Getenv("XDG_RUNTIME_DIR"),
+			"sway-yasm-*.sock"))
+		if len(socks) != 1 {
+			return fmt.Errorf("sockets %v", socks)
+		}
+		var err error
+		client, err = rpc.Dial("unix", socks[0])
+		return err
+	})
+	t.Cleanup(func() { client.Close() })
+
+	err := client.Call("Daemon.Hello",
+		daemon.HelloArgs{Version: daemon.ProtocolVersion}, &daemon.HelloReply{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
+
+// TestConcurrentRPC calls the RPC methods from many clients at once, while
+// sway emits events and PATH changes. Run with -race.
+func TestConcurrentRPC(t *testing.T) {
+	srv, err := swaytest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(srv.AddOutput("DP-1"))
+	must(srv.AddOutput("HDMI-A-1"))
+	must(srv.AddWorkspace("DP-1", "1"))
+	must(srv.AddWorkspace("HDMI-A-1", "2"))
+	var ids []int
+	for i := range 6 {
+		id, err := srv.AddWindow(strconv.Itoa(i%2+1), "app"+strconv.Itoa(i),
+			"title")
+		must(err)
+		ids = append(ids, id)
+	}
+
+	bin1, bin2 := t.TempDir(), t.TempDir()
+	touch := func(dir, name string) {
+		t.Helper()
+		must(os.WriteFile(filepath.Join(dir, name), nil, 0o755))
+	}
+	touch(bin1, "foo")
+	touch(bin2, "bar")
+
+	startDaemon(t, srv, bin1+string(os.PathListSeparator)+bin2)
+	client := dialDaemon(t)
+	eventually(t, func() error {
+		ids, err := windowIDs(client, daemon.WindowsArgs{})
+		if err == nil && len(ids) != 6 {
+			err = fmt.Errorf("windows %v", ids)
+		}
+		return err
+	})
+
+	const clients = 8
+	const calls = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, clients*calls)
+	for c := range clients {
+		client := dialDaemon(t)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range calls {
+				id := ids[(c+i)%len(ids)]
+				var err error
+				switch i % 7 {
+				case 0:
+					err = client.Call("Daemon.RemoteWindows",
+						daemon.WindowsArgs{}, &daemon.WindowsReply{})
+				case 1:
+					err = client.Call("Daemon.RemoteSpaces",
+						daemon.SpacesArgs{}, &daemon.SpacesReply{})
+				case 2:
+					err = client.Call("Daemon.RemoteFocusWinID",
+						daemon.WinArgs{ID: id}, &daemon.Empty{})
+				case 3:
+					err = client.Call("Daemon.RemoteGetPathFiles",
+						daemon.Empty{}, &daemon.PathFilesReply{})
+				case 4:
+					err = client.Call("Daemon.RemoteUsrCmd", daemon.UsrCmdArgs{
+						Name: "titlebar-toggle", DryRun: true,
+					}, &daemon.UsrCmdReply{})
+				case 5:
+					err = client.Call("Daemon.RemoteSetConfig",
+						daemon.ConfigArgs{Key: "watcher.debounce",
+							Value: strconv.Itoa(i) + "ms"}, &daemon.Empty{})
+				case 6:
+					err = client.Call("Daemon.RemoteGetConfig",
+						daemon.ConfigArgs{}, &daemon.ConfigReply{})
+				}
+				if err != nil {
+					errs <- fmt.Errorf("client %d call %d: %w", c, i, err)
+				}
+			}
+		}()
+	}
+
+	// meanwhile in sway and PATH
+	for i := range calls {
+		must(srv.Focus(ids[i%len(ids)]))
+		touch(bin1, "foo"+strconv.Itoa(i))
+		touch(bin2, "bar"+strconv.Itoa(i))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// all the executables get listed eventually
+	eventually(t, func() error {
+		var reply daemon.PathFilesReply
+		err := client.Call("Daemon.RemoteGetPathFiles", daemon.Empty{},
+			&reply)
+		if err == nil && len(reply.Files) != 2+2*calls {
+			err = fmt.Errorf("%d files", len(reply.Files))
+		}
+		return err
+	})
+}
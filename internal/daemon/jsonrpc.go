@@ -0,0 +1,364 @@
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// JSON-RPC 2.0 error codes
+const (
+	jsonParseError     = -32700
+	jsonInvalidRequest = -32600
+	jsonNoMethod       = -32601
+	jsonInvalidParams  = -32602
+	jsonServerError    = -32000
+	jsonSwayError      = -32001
+)
+
+// jsonMethod is a Remote* method callable over JSON-RPC.
+type jsonMethod struct {
+	fn    reflect.Value
+	args  reflect.Type
+	reply reflect.Type
+}
+
+type jsonRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type jsonResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  any             `json:"result,omitempty"`
+	Error   *jsonError      `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type jsonError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// MethodInfo describes a JSON-RPC method, see GET /methods.
+type MethodInfo struct {
+	Name   string `json:"name"`
+	Params any    `json:"params"`
+	Result any    `json:"result"`
+}
+
+// jsonPublic are the RPC methods exposed over JSON-RPC, without the
+// "Remote" prefix. The picker protocol stays internal.
+var jsonPublic = []string{
+	"Windows", "Spaces", "FocusSpace", "FocusWinID", "MoveSpaceToOutput",
+	"MoveWinToSpace", "WinToSpace", "SetConfig", "GetConfig", "GetPathFiles",
+	"Exec", "Copy", "UsrCmd", "Status",
+}
+
+// jsonMethods returns the public RPC methods of the daemon, named without
+// the "Remote" prefix, eg "Windows" for RemoteWindows.
+func jsonMethods(d *Daemon) map[string]jsonMethod {
+	methods := map[string]jsonMethod{}
+	val := reflect.ValueOf(d)
+	typ := val.Type()
+	errType := reflect.TypeOf((*error)(nil)).Elem()
+
+	for i := 0; i < typ.NumMethod(); i++ {
+		m := typ.Method(i)
+		name, ok := strings.CutPrefix(m.Name, "Remote")
+		if !ok || !slices.Contains(jsonPublic, name) {
+			continue
+		}
+		// same as net/rpc: func (args T1, reply *T2) error
+		t := m.Type
+		if t.NumIn() != 3 || t.NumOut() != 1 || t.Out(0) != errType ||
+			t.In(2).Kind() != reflect.Pointer {
+			continue
+		}
+
+		methods[name] = jsonMethod{
+			fn:    val.Method(i),
+			args:  t.In(1),
+			reply: t.In(2).Elem(),
+		}
+	}
+
+	return methods
+}
+
+// jsonHandler serves JSON-RPC 2.0 requests on POST /, the method list on
+// GET /methods and the event stream on GET /events.
+type jsonHandler struct {
+	d       *Daemon
+	methods map[string]jsonMethod
+}
+
+func newJSONHandler(d *Daemon) *jsonHandler {
+	return &jsonHandler{d: d, methods: jsonMethods(d)}
+}
+
+func (h *jsonHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// events are always available
+	if r.URL.Path == "/events" && r.Method == http.MethodGet {
+		h.serveEvents(w, r)
+		return
+	}
+
+	if !h.d.currentConfig().Daemon.JSONRPC {
+		http.Error(w, "JSON-RPC disabled, set daemon.json_rpc to true",
+			http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/methods" && r.Method == http.MethodGet:
+		h.serveMethods(w)
+	case r.URL.Path == "/" && r.Method == http.MethodPost:
+		h.serveCall(w, r)
+	default:
+		http.Error(w, "use POST / or GET /methods", http.StatusNotFound)
+	}
+}
+
+func (h *jsonHandler) serveMethods(w http.ResponseWriter) {
+	var list []MethodInfo
+	for name, m := range h.methods {
+		list = append(list, MethodInfo{
+			Name:   name,
+			Params: schema(m.args),
+			Result: schema(m.reply),
+		})
+	}
+	slices.SortFunc(list, func(a, b MethodInfo) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	writeJSON(w, map[string]any{
+		"protocol": ProtocolVersion,
+		"version":  Version(),
+		"methods":  list,
+	})
+}
+
+func (h *jsonHandler) serveCall(w http.ResponseWriter, r *http.Request) {
+	var req jsonRequest
+	// a null ID is still a request, only a missing one is a notification
+	var keys map[string]json.RawMessage
+	body, err := io.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(body, &keys)
+	}
+	if err == nil {
+		err = json.Unmarshal(body, &req)
+	}
+	if err != nil {
+		writeJSON(w, jsonFail(nil, jsonParseError, err.Error()))
+		return
+	}
+	_, hasID := keys["id"]
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		writeJSON(w, jsonFail(req.ID, jsonInvalidRequest,
+			`expected "jsonrpc": "2.0" and a method`))
+		return
+	}
+
+	m, ok := h.methods[req.Method]
+	if !ok {
+		writeJSON(w, jsonFail(req.ID, jsonNoMethod,
+			"unknown method "+req.Method+", see GET /methods"))
+		return
+	}
+
+	args := reflect.New(m.args)
+	if len(req.Params) > 0 && string(req.Params) != "null" {
+		dec := json.NewDecoder(strings.NewReader(string(req.Params)))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(args.Interface()); err != nil {
+			writeJSON(w, jsonFail(req.ID, jsonInvalidParams, err.Error()))
+			return
+		}
+	}
+
+	log.Printf("jsonrpc %s...", req.Method)
+	reply := reflect.New(m.reply)
+	out := m.fn.Call([]reflect.Value{args.Elem(), reply})
+	err, _ = out[0].Interface().(error)
+
+	// notifications don't get a response
+	if !hasID {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		code := jsonServerError
+		if errors.Is(err, ErrSwayUnavailable) {
+			code = jsonSwayError
+		}
+		writeJSON(w, jsonFail(req.ID, code, err.Error()))
+		return
+	}
+	writeJSON(w, jsonResponse{
+		JSONRPC: "2.0",
+		Result:  reply.Interface(),
+		ID:      req.ID,
+	})
+}
+
+func jsonFail(id json.RawMessage, code int, msg string) jsonResponse {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+
+	return jsonResponse{
+		JSONRPC: "2.0",
+		Error:   &jsonError{Code: code, Message: msg},
+		ID:      id,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Printf("json error: %s", err)
+	}
+}
+
+// schema returns a JSON schema of the type, as encoded by encoding/json.
+func schema(t reflect.Type) any {
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return map[string]any{"type": "string", "format": "date-time"}
+	case reflect.TypeOf(time.Duration(0)):
+		return map[string]any{"type": "integer", "description": "nanoseconds"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schema(t.Elem())
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16,
+		reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{
+			"type":                 "object",
+			"additionalProperties": schema(t.Elem()),
+		}
+	case reflect.Struct:
+		props := map[string]any{}
+		structFields(t, props)
+		return map[string]any{"type": "object", "properties": props}
+	}
+
+	return map[string]any{}
+}
+
+// structFields collects the JSON fields of the struct, including embedded
+// ones.
+func structFields(t reflect.Type, props map[string]any) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			structFields(f.Type, props)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = schema(f.Type)
+	}
+}
+
+// ///// ///// /////
+// ///// SOCKET
+// ///// ///// /////
+
+// peekConn is a connection with the first bytes already peeked.
+type peekConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *peekConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// isHTTP peeks into the connection to tell HTTP requests from gob.
+func isHTTP(conn *peekConn) (bool, error) {
+	head, err := conn.r.Peek(4)
+	if err != nil {
+		return false, err
+	}
+	switch string(head) {
+	case "GET ", "POST", "HEAD", "PUT ", "OPTI":
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// chanListener passes already accepted connections to an http.Server.
+type chanListener struct {
+	conns chan net.Conn
+	addr  net.Addr
+}
+
+func (l *chanListener) Accept() (net.Conn, error) {
+	conn, ok := <-l.conns
+	if !ok {
+		return nil, net.ErrClosed
+	}
+
+	return conn, nil
+}
+
+func (l *chanListener) Close() error {
+	return nil
+}
+
+func (l *chanListener) Addr() net.Addr {
+	return l.addr
+}
+
+// serveHTTP starts an HTTP server with the JSON-RPC handler and returns a
+// listener to pass connections to it.
+func serveHTTP(out *log.Logger, d *Daemon, addr net.Addr) *chanListener {
+	l := &chanListener{conns: make(chan net.Conn), addr: addr}
+	srv := &http.Server{
+		Handler:           newJSONHandler(d),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		err := srv.Serve(l)
+		out.Printf("http error: %s", err)
+	}()
+
+	return l
+}
@@ -0,0 +1,462 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pancsta/gosway/ipc"
+
+	"github.com/pancsta/sway-yasm/internal/config"
+)
+
+const (
+	// warmMinUptime prevents respawning a crashing warm picker in a loop.
+	warmMinUptime = 5 * time.Second
+	// warmShowTimeout is how long a session waits for a busy warm picker,
+	// before falling back to a cold one
+	warmShowTimeout = 500 * time.Millisecond
+	// warmPoll is the RemotePickerNext wait of clients not sending their own
+	warmPoll = time.Second
+)
+
+// cyclingPickers select the next row when re-invoked, while the others close.
+var cyclingPickers = []string{"switcher", "space-switcher"}
+
+// pickerSession is a shown picker, between RemotePickerShow and
+// RemotePickerHide.
+type pickerSession struct {
+	id int
+	// picker is the command, eg "switcher"
+	picker string
+	// pid is the client showing the picker
+	pid int
+	// term is true for the backends running in a terminal, which can cycle
+	term bool
+	// warm is true for sessions shown in the warm picker
+	warm bool
+	// attached is true once the picker listens for its actions, see
+	// RemotePickerAttach
+	attached bool
+	// cycles are the re-invocations before attaching
+	cycles int
+	// conID is the picker's window, if known
+	conID int
+}
+
+// cycling returns true if re-invoking the picker selects the next row.
+func (s *pickerSession) cycling() bool {
+	return s.term && slices.Contains(cyclingPickers, s.picker)
+}
+
+// stale returns true for sessions without a window, whose client exited.
+// Sessions with a window get reaped on its close event.
+func (s *pickerSession) stale() bool {
+	if s.conID != 0 {
+		return false
+	}
+	proc, _ := os.FindProcess(s.pid)
+
+	return proc.Signal(syscall.Signal(0)) != nil
+}
+
+// pickerSessions is the registry of the shown pickers, by their IDs. Showing
+// a picker closes the previous one, so there's at most one.
+type pickerSessions map[int]*pickerSession
+
+// shown returns the shown picker, if any.
+func (r pickerSessions) shown() *pickerSession {
+	for _, s := range r {
+		return s
+	}
+
+	return nil
+}
+
+// reap forgets the stale sessions, and the ones of the closed window, if any.
+func (r pickerSessions) reap(closedCon int) {
+	for id, s := range r {
+		if (closedCon != 0 && s.conID == closedCon) || s.stale() {
+			delete(r, id)
+		}
+	}
+}
+
+// warmPicker is a picker terminal spawned by the daemon, parked in the
+// scratchpad between sessions. See RemotePickerServe.
+type warmPicker struct {
+	conID int
+	// pid is the serving process within the terminal
+	pid     int
+	backend string
+	readyAt time.Time
+	// show passes the next session to RemotePickerNext
+	show chan PickerNextReply
+}
+
+// spawnWarmPicker starts a warm picker terminal, which registers itself via
+// RemotePickerServe.
+func (d *Daemon) spawnWarmPicker() error {
+	d.Logger.Printf("spawning the warm picker...")
+	if isDev() {
+		return d.SwayMsg("exec env YASM_DEBUG=1 sway-yasm warm-picker")
+	}
+
+	return d.SwayMsg("exec sway-yasm warm-picker")
+}
+
+// warmPickerEnabled returns true if the config wants a warm picker, which
+// only the terminal backends can use.
+func warmPickerEnabled(cfg *config.Config) bool {
+	return cfg.Picker.Warm &&
+		(cfg.Picker.Backend == "fzf" || cfg.Picker.Backend == "builtin")
+}
+
+// reapPickers forgets the sessions of a closed picker window, including the
+// warm picker. Returns true if the warm picker should be respawned.
+func (d *Daemon) reapPickers(st *state, e *swayEvent) bool {
+	if e.Type != eventWindow || e.Change != "close" {
+		return false
+	}
+	st.pickers.reap(e.Container.ID)
+	if st.warm == nil || e.Container.ID != st.warm.conID {
+		return false
+	}
+
+	uptime := time.Since(st.warm.readyAt)
+	st.warm = nil
+	if !warmPickerEnabled(st.cfg) {
+		return false
+	}
+	if uptime < warmMinUptime {
+		d.Logger.Printf("warm picker closed after %s, not respawning", uptime)
+		return false
+	}
+
+	return true
+}
+
+// closePicker closes a session removed from the registry. The warm picker
+// and the dmenu backends close on the event, other windows get killed.
+func (d *Daemon) closePicker(s *pickerSession) error {
+	d.Logger.Printf("closing picker %s #%d", s.picker, s.id)
+	d.events.publish(Event{Type: EventPicker, Picker: s.picker,
+		Session: s.id, Action: PickerClose})
+	if s.warm || s.conID == 0 {
+		return nil
+	}
+
+	return d.SwayMsg(`[con_id=%d] kill`, s.conID)
+}
+
+// findPickerWin returns the ID of the picker window running the process,
+// matched by the PIDs of the process' ancestors. A single foot server runs
+// many windows, so only the picker windows are considered.
+func (d *Daemon) findPickerWin(pid int) (int, error) {
+	tree, err := d.getTree()
+	if err != nil {
+		return 0, err
+	}
+	pids := pidAncestors(pid)
+
+	var find func(nodes []ipc.Node) int
+	find = func(nodes []ipc.Node) int {
+		for i := range nodes {
+			node := &nodes[i]
+			appID, _ := node.AppID.(string)
+			isPicker := node.Name == config.WindowID || appID == config.WindowID
+			if isPicker && slices.Contains(pids, node.Pid) {
+				return int(node.ID)
+			}
+			if id := find(node.Nodes); id != 0 {
+				return id
+			}
+			if id := find(node.FloatingNodes); id != 0 {
+				return id
+			}
+		}
+
+		return 0
+	}
+
+	id := find(tree.Nodes)
+	if id == 0 {
+		return 0, fmt.Errorf("no picker window for PID %d", pid)
+	}
+
+	return id, nil
+}
+
+// hasCon returns true if the container is within the nodes.
+func hasCon(nodes []ipc.Node, id int) bool {
+	for i := range nodes {
+		node := &nodes[i]
+		if int(node.ID) == id || hasCon(node.Nodes, id) ||
+			hasCon(node.FloatingNodes, id) {
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// pidAncestors returns the PID with its parent PIDs, read from /proc.
+func pidAncestors(pid int) []int {
+	pids := []int{pid}
+	for pid > 1 {
+		stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		if err != nil {
+			break
+		}
+		// the command can contain spaces, the fields after it can't
+		fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat),
+			')')+1:]))
+		if len(fields) < 2 {
+			break
+		}
+		pid, err = strconv.Atoi(fields[1])
+		if err != nil {
+			break
+		}
+		pids = append(pids, pid)
+	}
+
+	return pids
+}
+
+// ///// ///// /////
+// ///// RPC
+// ///// ///// /////
+
+// RemotePickerShow is an RPC method
+func (d *Daemon) RemotePickerShow(
+	args PickerShowArgs, reply *PickerShowReply,
+) error {
+	var (
+		closed   *pickerSession
+		cycled   *pickerSession
+		warm     *warmPicker
+		showWarm bool
+	)
+	d.exec(func(st *state) {
+		st.pickers.reap(0)
+		prev := st.pickers.shown()
+
+		// re-invoked, cycle or close
+		if prev != nil && prev.picker == args.Picker {
+			switch {
+			case !prev.cycling():
+				delete(st.pickers, prev.id)
+				closed = prev
+			case prev.attached:
+				cycled = prev
+			default:
+				prev.cycles++
+			}
+			return
+		}
+
+		// replace the previous one
+		if prev != nil {
+			delete(st.pickers, prev.id)
+			closed = prev
+		}
+		st.pickerSeq++
+		s := &pickerSession{
+			id:     st.pickerSeq,
+			picker: args.Picker,
+			pid:    args.PID,
+			term:   args.Term,
+		}
+		st.pickers[s.id] = s
+		reply.Session = s.id
+
+		if st.warm != nil && st.warm.backend == args.Backend {
+			s.warm = true
+			s.conID = st.warm.conID
+			warm = st.warm
+			// still shown by the previous session
+			showWarm = closed == nil || !closed.warm
+			reply.Warm = true
+			return
+		}
+		reply.Open = true
+	})
+
+	if cycled != nil {
+		d.events.publish(Event{Type: EventPicker, Picker: cycled.picker,
+			Session: cycled.id, Action: PickerCycle})
+	}
+	if closed != nil {
+		if err := d.closePicker(closed); err != nil {
+			d.Logger.Printf("error: %s", err)
+		}
+	}
+	if warm == nil {
+		return nil
+	}
+
+	// the warm picker loads the list while getting shown
+	select {
+	case warm.show <- PickerNextReply{Picker: args.Picker, Args: args.Args,
+		Session: reply.Session}:
+	case <-time.After(warmShowTimeout):
+		d.Logger.Printf("warm picker busy, showing picker %s #%d cold",
+			args.Picker, reply.Session)
+		d.exec(func(st *state) {
+			if s, ok := st.pickers[reply.Session]; ok {
+				s.warm = false
+				s.conID = 0
+			}
+		})
+		reply.Warm = false
+		reply.Open = true
+		return nil
+	}
+	if !showWarm {
+		return nil
+	}
+	err := d.SwayMsg(`[con_id=%d] scratchpad show, move position center`,
+		warm.conID)
+	if err != nil {
+		select {
+		case <-warm.show:
+		default:
+		}
+		d.exec(func(st *state) {
+			delete(st.pickers, reply.Session)
+		})
+	}
+
+	return err
+}
+
+// RemotePickerAttach is an RPC method
+func (d *Daemon) RemotePickerAttach(
+	args PickerAttachArgs, reply *PickerAttachReply,
+) error {
+	var err error
+	findWin := false
+	d.exec(func(st *state) {
+		s, ok := st.pickers[args.Session]
+		if !ok {
+			err = fmt.Errorf("picker session %d closed", args.Session)
+			return
+		}
+		s.attached = true
+		reply.Cycles = s.cycles
+		s.cycles = 0
+		findWin = s.term && s.conID == 0
+	})
+	if err != nil || !findWin {
+		return err
+	}
+
+	// reaped on the window's close event
+	conID, err := d.findPickerWin(args.PID)
+	if err != nil {
+		d.Logger.Printf("error: %s", err)
+		return nil
+	}
+	d.exec(func(st *state) {
+		if s, ok := st.pickers[args.Session]; ok {
+			s.conID = conID
+		}
+	})
+
+	return nil
+}
+
+// RemotePickerHide is an RPC method
+func (d *Daemon) RemotePickerHide(args PickerHideArgs, _ *Empty) error {
+	conID := 0
+	d.exec(func(st *state) {
+		delete(st.pickers, args.Session)
+
+		// hide the warm picker, unless showing the next session
+		if st.warm == nil || st.warm.pid != args.PID {
+			return
+		}
+		if s := st.pickers.shown(); s != nil && s.warm {
+			return
+		}
+		conID = st.warm.conID
+	})
+	if conID == 0 {
+		return nil
+	}
+
+	return d.SwayMsg(`[con_id=%d] move scratchpad`, conID)
+}
+
+// RemotePickerServe is an RPC method
+func (d *Daemon) RemotePickerServe(args PickerServeArgs, _ *Empty) error {
+	conID, err := d.findPickerWin(args.PID)
+	if err != nil {
+		return err
+	}
+
+	prev := 0
+	d.exec(func(st *state) {
+		// spawned before a config change
+		if !warmPickerEnabled(st.cfg) || st.cfg.Picker.Backend != args.Backend {
+			err = fmt.Errorf("warm picker %s not enabled", args.Backend)
+			return
+		}
+		if st.warm != nil {
+			prev = st.warm.conID
+		}
+		st.warm = &warmPicker{
+			conID:   conID,
+			pid:     args.PID,
+			backend: args.Backend,
+			readyAt: time.Now(),
+			show:    make(chan PickerNextReply, 1),
+		}
+	})
+	if err != nil {
+		return err
+	}
+	d.Logger.Printf("warm picker ready #%d", conID)
+
+	msgs := []string{fmt.Sprintf(`[con_id=%d] move scratchpad`, conID)}
+	if prev != 0 && prev != conID {
+		msgs = append(msgs, fmt.Sprintf(`[con_id=%d] kill`, prev))
+	}
+
+	return d.SwayMsgs(msgs)
+}
+
+// RemotePickerNext is an RPC method
+func (d *Daemon) RemotePickerNext(
+	args PickerServeArgs, reply *PickerNextReply,
+) error {
+	var warm *warmPicker
+	d.exec(func(st *state) {
+		if st.warm != nil && st.warm.pid == args.PID {
+			warm = st.warm
+		}
+	})
+	if warm == nil {
+		return errors.New("not the warm picker")
+	}
+
+	// return before the client's timeout
+	poll := args.Poll
+	if poll <= 0 {
+		poll = warmPoll
+	}
+	select {
+	case next := <-warm.show:
+		*reply = next
+	case <-time.After(poll):
+	}
+
+	return nil
+}
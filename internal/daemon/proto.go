@@ -0,0 +1,179 @@
+package daemon
+
+import (
+	"time"
+
+	"github.com/pancsta/sway-yasm/internal/config"
+	"github.com/pancsta/sway-yasm/internal/types"
+)
+
+// ProtocolVersion changes with each incompatible change of the RPC methods,
+// see Daemon.Hello. Version 1 passed RPCArgs to all the methods, version 2
+// daemons would ignore UsrCmdArgs.DryRun and run the commands, version 3 had
+// RemoteShouldOpen instead of the picker sessions, version 4 hid the pickers
+// by PID.
+const ProtocolVersion = 5
+
+// Empty is used by RPC methods without args or a reply.
+type Empty struct{}
+
+type HelloArgs struct {
+	// Version is the client's ProtocolVersion
+	Version int
+	// Client is the client's binary version
+	Client string
+}
+
+type HelloReply struct {
+	// Version is the daemon's ProtocolVersion
+	Version int
+	// Daemon is the daemon's binary version
+	Daemon string
+}
+
+// WindowsArgs is the switcher's scope.
+type WindowsArgs struct {
+	CurrentOutputOnly bool
+	CurrentSpaceOnly  bool
+}
+
+type WindowsReply struct {
+	// Windows in the MRU order
+	Windows []types.WindowData
+	// Space is the focused workspace
+	Space types.SpaceData
+	// Visible are names of the visible workspaces, including the focused one
+	Visible []string
+}
+
+type SpacesArgs struct {
+	SkipCurrentOutput bool
+}
+
+// SpaceInfo is a workspace with a summary of its windows.
+type SpaceInfo struct {
+	types.SpaceData
+	Windows int
+	// Apps in the MRU order
+	Apps []string
+}
+
+type SpacesReply struct {
+	// Spaces in the MRU order, without the scratchpad
+	Spaces []SpaceInfo
+}
+
+type SpaceArgs struct {
+	ID int
+}
+
+type MoveSpaceArgs struct {
+	Workspace string
+}
+
+type WinArgs struct {
+	ID int
+}
+
+type WinToSpaceArgs struct {
+	SpaceNum int
+}
+
+type PickerShowArgs struct {
+	// Picker is the command, eg "switcher"
+	Picker string
+	// Args of the "fzf" command, eg the scope flags
+	Args []string
+	// Backend is the picker backend, eg "fzf"
+	Backend string
+	// Term is true for the backends running in a terminal
+	Term bool
+	PID  int
+}
+
+// PickerShowReply has neither Open nor Warm set when an open picker got
+// cycled or closed instead.
+type PickerShowReply struct {
+	// Open is true when the client should open the picker
+	Open bool
+	// Warm is true when the warm picker got shown instead
+	Warm    bool
+	Session int
+}
+
+// PickerAttachArgs are sent by the process showing the picker, once it
+// listens for the EventPicker actions of the session.
+type PickerAttachArgs struct {
+	Session int
+	PID     int
+}
+
+type PickerAttachReply struct {
+	// Cycles are the re-invocations before attaching
+	Cycles int
+}
+
+type PickerHideArgs struct {
+	Session int
+	// PID is the client's, or the warm picker's
+	PID int
+}
+
+// PickerServeArgs registers the warm picker and waits for its sessions.
+type PickerServeArgs struct {
+	PID     int
+	Backend string
+	// Poll is how long RemotePickerNext waits for a session, within the
+	// client's rpc_timeout
+	Poll time.Duration
+}
+
+// PickerNextReply is empty when there's no session to show yet.
+type PickerNextReply struct {
+	Picker  string
+	Args    []string
+	Session int
+}
+
+type ConfigArgs struct {
+	// Key is dotted, eg "daemon.max_tracked"
+	Key   string
+	Value string
+}
+
+type ConfigReply struct {
+	// YAML is the value of the key, or the whole config
+	YAML string
+}
+
+type PathFilesReply struct {
+	Files []string
+}
+
+type ExecArgs struct {
+	Path string
+}
+
+type CopyArgs struct {
+	Text string
+}
+
+type UsrCmdArgs struct {
+	Name string
+	Args string
+	// DryRun runs the command on a copy of the state, without running any
+	// sway commands
+	DryRun bool
+}
+
+type UsrCmdReply struct {
+	Output string
+	// Commands are the sway commands of a dry run
+	Commands []string
+}
+
+// StatusReply is Status with the effective config.
+type StatusReply struct {
+	Status
+	Config *config.Config
+}
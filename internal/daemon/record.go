@@ -0,0 +1,223 @@
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pancsta/gosway/ipc"
+
+	"github.com/pancsta/sway-yasm/internal/config"
+	"github.com/pancsta/sway-yasm/internal/types"
+)
+
+// record kinds
+const (
+	// recordConnect is the tree read after (re)connecting to sway
+	recordConnect = "connect"
+	// recordEvent is a received sway event
+	recordEvent = "event"
+	// recordTree is a tree requested while handling the previous event
+	recordTree = "tree"
+	// recordRefresh is a tree with workspaces, requested while handling the
+	// previous event
+	recordRefresh = "refresh"
+	// recordCommand is a command result
+	recordCommand = "command"
+)
+
+// record is a single line of a session recording, see Daemon.RecordPath.
+type record struct {
+	Time time.Time `json:"time"`
+	Kind string    `json:"kind"`
+	// Tree and Workspaces for connect, tree and refresh
+	Tree       *ipc.Tree        `json:"tree,omitempty"`
+	Workspaces []*ipc.Workspace `json:"workspaces,omitempty"`
+	// Config is the YAML of the active config, for connect
+	Config string `json:"config,omitempty"`
+	// EventType is the name of the event's type, eg "window"
+	EventType string     `json:"event_type,omitempty"`
+	Event     *swayEvent `json:"event,omitempty"`
+	// Command and its Error, if any
+	Command string `json:"command,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// recorder writes records as NDJSON.
+type recorder struct {
+	mx   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+func newRecorder(path string) (*recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &recorder{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+func (r *recorder) write(rec record) error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	rec.Time = time.Now()
+
+	return r.enc.Encode(rec)
+}
+
+func (r *recorder) Close() error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	return r.file.Close()
+}
+
+// record writes to the recording, if enabled.
+func (d *Daemon) record(rec record) {
+	if d.recorder == nil {
+		return
+	}
+	if err := d.recorder.write(rec); err != nil {
+		d.Logger.Printf("record error: %s", err)
+	}
+}
+
+// recordedConfig returns the YAML of the active config, if recording.
+func (d *Daemon) recordedConfig() string {
+	if d.recorder == nil {
+		return ""
+	}
+	cfg, err := d.currentConfig().YAML()
+	if err != nil {
+		d.Logger.Printf("record error: %s", err)
+	}
+
+	return cfg
+}
+
+// ///// ///// /////
+// ///// REPLAY
+// ///// ///// /////
+
+// ReplayResult is the state after replaying a recording.
+type ReplayResult struct {
+	// Events is the number of replayed events
+	Events   int                         `json:"events"`
+	WinFocus WindowFocus                 `json:"win_focus"`
+	WinData  map[string]types.WindowData `json:"win_data"`
+}
+
+// Replay feeds a recording of a daemon session (see Daemon.RecordPath)
+// through the event handling, without sway, and returns the resulting state.
+// Trees requested during the session are served from the recording, so
+// the result is deterministic. The config comes from the recording too,
+// falling back to the defaults for older recordings.
+func Replay(r io.Reader, logger *log.Logger) (*ReplayResult, error) {
+	records, err := readRecords(r)
+	if err != nil {
+		return nil, err
+	}
+
+	mem := newMemCompositor(nil, nil)
+	d := &Daemon{
+		Logger: logger,
+		conn:   mem,
+		state:  newState(config.Default()),
+		queue:  make(chan func()),
+	}
+	go d.loop()
+	// ends the loop
+	defer close(d.queue)
+
+	events := 0
+	for i, rec := range records {
+		switch rec.Kind {
+
+		case recordConnect:
+			if rec.Tree == nil {
+				return nil, fmt.Errorf("record %d: missing tree", i+1)
+			}
+			cfg := config.Default()
+			if rec.Config != "" {
+				cfg, err = config.Parse([]byte(rec.Config))
+				if err != nil {
+					return nil, fmt.Errorf("record %d: %w", i+1, err)
+				}
+			}
+			mem.setTree(rec.Tree, rec.Workspaces)
+			d.exec(func(st *state) {
+				st.cfg = cfg
+				rebuild(st, rec.Tree, rec.Workspaces)
+			})
+
+		case recordEvent:
+			if rec.Event == nil {
+				return nil, fmt.Errorf("record %d: missing event", i+1)
+			}
+			e := *rec.Event
+			e.Type = -1
+			for typ, name := range eventNames {
+				if name == rec.EventType {
+					e.Type = typ
+				}
+			}
+			if e.Type == -1 {
+				return nil, fmt.Errorf("record %d: unknown event type %q", i+1,
+					rec.EventType)
+			}
+			if e.Type == eventShutdown {
+				continue
+			}
+
+			// serve the trees requested while handling this event
+			for _, next := range records[i+1:] {
+				if next.Kind == recordEvent || next.Kind == recordConnect {
+					break
+				}
+				switch next.Kind {
+				case recordTree:
+					spaces, _ := mem.GetWorkspaces()
+					mem.setTree(next.Tree, spaces)
+				case recordRefresh:
+					mem.setTree(next.Tree, next.Workspaces)
+				}
+			}
+			d.handleEvent(&e)
+			events++
+		}
+	}
+
+	ret := &ReplayResult{Events: events}
+	d.exec(func(st *state) {
+		ret.WinFocus, ret.WinData = st.snapshot()
+	})
+
+	return ret, nil
+}
+
+func readRecords(r io.Reader) ([]record, error) {
+	var records []record
+	scanner := bufio.NewScanner(r)
+	// trees can be large
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		records = append(records, rec)
+	}
+
+	return records, scanner.Err()
+}
@@ -1,128 +1,129 @@
 package daemon
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/rpc"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
-	"syscall"
 	"time"
 
-	ss "github.com/pancsta/sway-yasm/internal/watcher/states"
+	"github.com/pancsta/sway-yasm/internal/config"
 	usrCmds "github.com/pancsta/sway-yasm/pkg/usr-cmds"
 )
 
 // RPC
 
-var client *rpc.Client
-
-type RPCArgs struct {
-	WinID             int
-	SpaceNum          int
-	Workspace         string
-	PID               int
-	MouseFollowsFocus bool
-	ExePath           string
-	UsrCmd            string
-	UsrArgs           string
-	Clipboard         string
-}
-
-// RemoteWinList is an RPC method
-func (d *Daemon) RemoteWinList(_ RPCArgs, reply *string) error {
-	ids := ""
-	for _, id := range d.winFocus {
-		ids += fmt.Sprintf("%s ", id)
+// Hello is an RPC method and has to be called first on each connection, see
+// helloCodec.
+func (d *Daemon) Hello(args HelloArgs, reply *HelloReply) error {
+	reply.Version = ProtocolVersion
+	reply.Daemon = Version()
+	if args.Version != ProtocolVersion {
+		return fmt.Errorf("%w: client %s speaks v%d, daemon %s speaks v%d",
+			ErrProtocolMismatch, args.Client, args.Version, Version(),
+			ProtocolVersion)
 	}
-	*reply = ids
+
 	return nil
 }
 
-// RemoteFZFList is an RPC method
-func (d *Daemon) RemoteFZFList(_ RPCArgs, reply *string) error {
-	ret := ""
-	// TODO extract
-	for _, id := range d.winFocus {
-		data := d.winData[id]
-		display := strings.Replace(data.Output, "HEADLESS-", "H-", 1)
-		// ret += fmt.Sprintf("%-*s (%s) %s| %-*s | %-*s | %-*s \n",
-		ret += fmt.Sprintf("%-*s | %-*s | %-*s | %-*s (%s) \n",
-			lenDisplay, maxLen(display, lenDisplay),
-			lenSpace, maxLen(data.Workspace, lenSpace),
-			lenApp, maxLen(data.App, lenApp),
-			lenTitle, maxLen(data.Title, lenTitle),
-			id,
-		)
-	}
-	*reply = ret
+// RemoteUnsupported is an RPC method, which replaces all the calls before
+// Hello.
+func (d *Daemon) RemoteUnsupported(_ Empty, _ *Empty) error {
+	return fmt.Errorf("%w: the client is older than the daemon (v%d), "+
+		"update sway-yasm", ErrProtocolMismatch, ProtocolVersion)
+}
+
+// RemoteWindows is an RPC method
+func (d *Daemon) RemoteWindows(args WindowsArgs, reply *WindowsReply) error {
+	d.exec(func(st *state) {
+		reply.Space = st.spaces[st.focusedSpace]
+		for _, s := range st.spaces {
+			if s.Visible {
+				reply.Visible = append(reply.Visible, s.Name)
+			}
+		}
+
+		// scope
+		for _, id := range st.winFocus {
+			data := st.winData[id]
+			if args.CurrentOutputOnly && data.Output != reply.Space.Output {
+				continue
+			}
+			if args.CurrentSpaceOnly && data.Workspace != reply.Space.Name {
+				continue
+			}
+			reply.Windows = append(reply.Windows, data)
+		}
+	})
+
 	return nil
 }
 
-// RemoteFZFListPickWin is an RPC method
-func (d *Daemon) RemoteFZFListPickWin(_ RPCArgs, reply *string) error {
-	space := d.winData[d.winFocus[0]].Workspace
-	ret := ""
-	// TODO extract
-	for _, id := range d.winFocus {
-		data := d.winData[id]
-		// skip same workspace
-		if data.Workspace == space {
-			continue
+// RemoteSpaces is an RPC method
+func (d *Daemon) RemoteSpaces(args SpacesArgs, reply *SpacesReply) error {
+	d.exec(func(st *state) {
+		output := st.spaces[st.focusedSpace].Output
+		for _, id := range st.spaceFocus {
+			space := st.spaces[id]
+			// skip the scratchpad
+			if space.Name == "__i3_scratch" {
+				continue
+			}
+			if args.SkipCurrentOutput && space.Output == output {
+				continue
+			}
+
+			// collect windows in the MRU order
+			info := SpaceInfo{SpaceData: space}
+			for _, winID := range st.winFocus {
+				win := st.winData[winID]
+				if win.Workspace != space.Name {
+					continue
+				}
+				info.Windows++
+				if !slices.Contains(info.Apps, win.App) {
+					info.Apps = append(info.Apps, win.App)
+				}
+			}
+			reply.Spaces = append(reply.Spaces, info)
 		}
-		display := strings.Replace(data.Output, "HEADLESS-", "H-", 1)
-		// ret += fmt.Sprintf("%-*s (%s) %s| %-*s | %-*s | %-*s \n",
-		ret += fmt.Sprintf("%-*s | %-*s | %-*s | %-*s (%s) \n",
-			lenDisplay, maxLen(display, lenDisplay),
-			lenSpace, maxLen(data.Workspace, lenSpace),
-			lenApp, maxLen(data.App, lenApp),
-			lenTitle, maxLen(data.Title, lenTitle),
-			id,
-		)
-	}
-	*reply = ret
+	})
+
 	return nil
 }
 
-// RemoteFZFListPickSpace is an RPC method
-func (d *Daemon) RemoteFZFListPickSpace(_ RPCArgs, reply *string) error {
-	currWin := d.FocusedWindow()
-	spaces, err := d.ListSpaces([]string{currWin.Output})
-	if err != nil {
+// RemoteFocusSpace is an RPC method
+func (d *Daemon) RemoteFocusSpace(args SpaceArgs, _ *Empty) error {
+	var name string
+	d.exec(func(st *state) {
+		name = st.spaces[strconv.Itoa(args.ID)].Name
+	})
+	if name == "" {
+		err := errors.New("workspace not found")
 		log.Printf("error: %s", err)
 		return err
 	}
-	*reply = strings.Join(spaces, "\n")
-	return nil
-}
 
-// RemoteShouldOpen is an RPC method
-func (d *Daemon) RemoteShouldOpen(args RPCArgs, reply *string) error {
-	if d.openedByPID == 0 {
-		*reply = "true"
-		d.openedByPID = args.PID
-		d.openedAt = time.Now()
-		return nil
-	}
-	// check if the holding process is alive
-	proc, _ := os.FindProcess(d.openedByPID)
-	timeoutOut := time.Now().Sub(d.openedAt) > pidTimeout
-	if dead := proc.Signal(syscall.Signal(0)); dead != nil || timeoutOut {
-		d.openedByPID = args.PID
-		d.openedAt = time.Now()
-		*reply = "true"
-	} else {
-		*reply = "false"
+	log.Printf("focusing workspace %s...", name)
+	err := d.SwayMsg(`workspace --no-auto-back-and-forth "%s"`, name)
+	if err != nil {
+		log.Printf("error: %s", err)
+		return err
 	}
 	return nil
 }
 
 // RemoteFocusWinID is an RPC method
-func (d *Daemon) RemoteFocusWinID(args RPCArgs, _ *string) error {
-	log.Printf("focusing %d...", args.WinID)
-	err := d.FocusWinID(args.WinID)
+func (d *Daemon) RemoteFocusWinID(args WinArgs, _ *Empty) error {
+	log.Printf("focusing %d...", args.ID)
+	err := d.FocusWinID(args.ID)
 	if err != nil {
 		log.Printf("error: %s", err)
 		return err
@@ -131,7 +132,7 @@ func (d *Daemon) RemoteFocusWinID(args RPCArgs, _ *string) error {
 }
 
 // RemoteMoveSpaceToOutput is an RPC method
-func (d *Daemon) RemoteMoveSpaceToOutput(args RPCArgs, _ *string) error {
+func (d *Daemon) RemoteMoveSpaceToOutput(args MoveSpaceArgs, _ *Empty) error {
 	currentWin := d.FocusedWindow()
 	if currentWin.Output == "" {
 		err := errors.New("no focused window / output")
@@ -149,20 +150,20 @@ func (d *Daemon) RemoteMoveSpaceToOutput(args RPCArgs, _ *string) error {
 }
 
 // RemoteMoveWinToSpace is an RPC method
-func (d *Daemon) RemoteMoveWinToSpace(args RPCArgs, _ *string) error {
+func (d *Daemon) RemoteMoveWinToSpace(args WinArgs, _ *Empty) error {
 	space := d.FocusedWindow().Workspace
 	if space == "" {
 		err := errors.New("no focused window / space")
 		log.Printf("error: %s", err)
 		return err
 	}
-	log.Printf("moving win %d to %s", args.WinID, space)
-	_, err := d.conn.RunSwayCommand(fmt.Sprintf(`[con_id="%d"] move workspace %s`, args.WinID, space))
+	log.Printf("moving win %d to %s", args.ID, space)
+	err := d.SwayMsg(`[con_id="%d"] move workspace %s`, args.ID, space)
 	if err != nil {
 		log.Printf("error: %s", err)
 		return err
 	}
-	err = d.FocusWinID(args.WinID)
+	err = d.FocusWinID(args.ID)
 	if err != nil {
 		log.Printf("error: %s", err)
 		return err
@@ -171,37 +172,44 @@ func (d *Daemon) RemoteMoveWinToSpace(args RPCArgs, _ *string) error {
 }
 
 // RemoteSetConfig is an RPC method
-func (d *Daemon) RemoteSetConfig(args RPCArgs, _ *string) error {
-	log.Printf("RemoteSetConfig %v...", args.MouseFollowsFocus)
-	d.MouseFollowsFocus = args.MouseFollowsFocus
-	if !d.MouseFollowsFocus {
-		// set the pointer to all the outputs
-		_, err := d.conn.RunSwayCommand(`input 0:0:wlr_virtual_pointer_v1 map_to_output "*"`)
-		if err != nil {
-			return err
-		}
+func (d *Daemon) RemoteSetConfig(args ConfigArgs, _ *Empty) error {
+	log.Printf("RemoteSetConfig %s=%s...", args.Key, args.Value)
+
+	return d.updateConfig(func(prev *config.Config) (*config.Config, error) {
+		return prev.With(args.Key, args.Value)
+	})
+}
+
+// RemoteGetConfig is an RPC method. Returns the whole config without a key.
+func (d *Daemon) RemoteGetConfig(args ConfigArgs, reply *ConfigReply) error {
+	var err error
+	cfg := d.currentConfig()
+	if args.Key == "" {
+		reply.YAML, err = cfg.YAML()
+	} else {
+		reply.YAML, err = cfg.Get(args.Key)
 	}
 
-	return nil
+	return err
 }
 
 // RemoteGetPathFiles is an RPC method
-func (d *Daemon) RemoteGetPathFiles(args RPCArgs, ret *string) error {
+func (d *Daemon) RemoteGetPathFiles(_ Empty, reply *PathFilesReply) error {
 	log.Printf("RemoteGetPathFiles...")
-	<-d.watcher.Mach.When1(ss.AllRefreshed, nil)
+	<-d.watcher.WhenRefreshed()
 	log.Printf("AllRefreshed...")
 	d.watcher.ResultsLock.Lock()
 	defer d.watcher.ResultsLock.Unlock()
-	*ret += strings.Join(d.watcher.Results, "\n")
+	reply.Files = slices.Clone(d.watcher.Results)
 
 	return nil
 }
 
 // RemoteExec is an RPC method
-func (d *Daemon) RemoteExec(args RPCArgs, ret *string) error {
+func (d *Daemon) RemoteExec(args ExecArgs, _ *Empty) error {
 	log.Printf("RemoteExec...")
-	path := args.ExePath
-	_, err := d.conn.RunSwayCommand("exec " + path)
+	path := args.Path
+	err := d.SwayMsgs([]string{"exec " + path})
 	if err != nil {
 		return err
 	}
@@ -210,7 +218,7 @@ func (d *Daemon) RemoteExec(args RPCArgs, ret *string) error {
 }
 
 // RemoteWinToSpace is an RPC method
-func (d *Daemon) RemoteWinToSpace(args RPCArgs, ret *string) error {
+func (d *Daemon) RemoteWinToSpace(args WinToSpaceArgs, _ *Empty) error {
 	log.Printf("RemoteWinToSpace...")
 
 	cw := d.FocusedWindow()
@@ -223,7 +231,7 @@ func (d *Daemon) RemoteWinToSpace(args RPCArgs, ret *string) error {
 }
 
 // RemoteCopy is an RPC method
-func (d *Daemon) RemoteCopy(args RPCArgs, ret *string) error {
+func (d *Daemon) RemoteCopy(args CopyArgs, _ *Empty) error {
 	log.Printf("RemoteCopy...")
 
 	// create a temp file
@@ -238,7 +246,7 @@ func (d *Daemon) RemoteCopy(args RPCArgs, ret *string) error {
 	}()
 
 	// pass the clipboard through listeners
-	contents := args.Clipboard
+	contents := args.Text
 	for _, fn := range usrCmds.Listeners["clipboard"] {
 		contents = fn.ClipListenerFunc(d, contents)
 	}
@@ -255,53 +263,39 @@ func (d *Daemon) RemoteCopy(args RPCArgs, ret *string) error {
 	if err != nil {
 		return err
 	}
+	d.events.publish(Event{Type: EventClipboard, Text: contents})
 
 	return nil
 }
 
-func RemoteCall(method string, args RPCArgs) (string, error) {
-	// TODO timeout
-	log.Printf("rpcCall %s...", method)
-
-	var err error
-	url := rpcHost
-	if os.Getenv("YASM_DEBUG") != "" {
-		url = rpcHostDbg
-	}
-
-	if client == nil {
-		client, err = rpc.Dial("tcp", url)
-		if err != nil {
-			fmt.Println("rpc connection error, is the daemon running?")
-			os.Exit(1)
-		}
-	}
-	var reply string
-	err = client.Call(method, args, &reply)
-	if err != nil {
-		return "", err
-	}
-
-	return reply, nil
-}
-
 // RemoteUsrCmd is an RPC method
-func (d *Daemon) RemoteUsrCmd(rpcArgs RPCArgs, rpcRet *string) error {
-	log.Printf("RemoteUsrCmd... " + rpcArgs.UsrCmd)
+func (d *Daemon) RemoteUsrCmd(rpcArgs UsrCmdArgs, reply *UsrCmdReply) error {
+	log.Printf("RemoteUsrCmd... " + rpcArgs.Name)
 
 	// init
 	var (
 		cmdRet string
 		err    error
-		args   = parseFlags(strings.Trim(rpcArgs.UsrArgs, " \n"))
+		args   = parseFlags(strings.Trim(rpcArgs.Args, " \n"))
+		api    = d
 	)
 
+	// dry runs on a copy, unless the whole daemon is dry
+	if rpcArgs.DryRun && !d.DryRun {
+		api = d.dryRunCopy()
+		defer close(api.queue)
+	}
+	start := 0
+	if api.DryRun {
+		start = api.dryRun.len()
+	}
+
 	// run
 	for name, fn := range usrCmds.Registered {
-		if name != rpcArgs.UsrCmd {
+		if name != rpcArgs.Name {
 			continue
 		}
-		cmdRet, err = fn(d, args)
+		cmdRet, err = fn(api, args)
 		break
 	}
 
@@ -311,28 +305,52 @@ func (d *Daemon) RemoteUsrCmd(rpcArgs RPCArgs, rpcRet *string) error {
 		return err
 	}
 
+	if !rpcArgs.DryRun {
+		d.events.publish(Event{
+			Type:   EventUsrCmd,
+			UsrCmd: rpcArgs.Name,
+			Args:   rpcArgs.Args,
+		})
+	}
+
 	// ret
 	log.Printf("cmdRet: %s", cmdRet)
-	*rpcRet = cmdRet
+	reply.Output = cmdRet
+	if api.DryRun {
+		reply.Commands = api.dryRun.since(start)
+	}
 	return nil
 }
 
 // SERVER
 
-func rpcServer(out *log.Logger, server any) {
-	err := rpc.Register(server)
-
+func rpcServer(out *log.Logger, d *Daemon, l net.Listener) {
+	server := rpc.NewServer()
+	err := server.RegisterName("Daemon", d)
 	if err != nil {
 		out.Fatal("register error:", err)
 	}
-	url := rpcHost
-	if os.Getenv("YASM_DEBUG") != "" {
-		url = rpcHostDbg
-	}
 
-	l, err := net.Listen("tcp", url)
-	if err != nil {
-		out.Fatal("listen error:", err)
+	httpConns := serveHTTP(out, d, l.Addr())
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			out.Printf("accept error: %s", err)
+			return
+		}
+
+		// gob or JSON-RPC over HTTP
+		go func() {
+			pc := &peekConn{Conn: conn, r: bufio.NewReader(conn)}
+			web, err := isHTTP(pc)
+			switch {
+			case err != nil:
+				conn.Close()
+			case web:
+				httpConns.conns <- pc
+			default:
+				server.ServeCodec(newHelloCodec(pc))
+			}
+		}()
 	}
-	rpc.Accept(l)
 }
@@ -0,0 +1,116 @@
+package daemon
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/pancsta/sway-yasm/internal/config"
+)
+
+// ///// ///// /////
+// ///// RPC SOCKET
+// ///// ///// /////
+
+// rpcSocketPath returns the RPC socket of the current sway session, unless
+// set in the config. Each SWAYSOCK gets its own daemon.
+func rpcSocketPath(cfg *config.Config) string {
+	if cfg.Daemon.RPCSocket != "" {
+		return cfg.Daemon.RPCSocket
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(os.Getenv("SWAYSOCK")))
+	name := fmt.Sprintf("sway-yasm-%08x", hash.Sum32())
+	if isDev() {
+		name += "-dbg"
+	}
+
+	return filepath.Join(runtimeDir(), name+".sock")
+}
+
+// clientSocketPath returns the socket of the daemon to call. Without
+// SWAYSOCK, eg over SSH, the only running daemon is picked.
+func clientSocketPath(cfg *config.Config) string {
+	if cfg.Daemon.RPCSocket != "" || os.Getenv("SWAYSOCK") != "" {
+		return rpcSocketPath(cfg)
+	}
+
+	pattern := "sway-yasm-????????.sock"
+	if isDev() {
+		pattern = "sway-yasm-????????-dbg.sock"
+	}
+	matches, _ := filepath.Glob(filepath.Join(runtimeDir(), pattern))
+	if len(matches) == 1 {
+		return matches[0]
+	}
+
+	return rpcSocketPath(cfg)
+}
+
+// runtimeDir returns XDG_RUNTIME_DIR, or a private dir in /tmp.
+func runtimeDir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return dir
+	}
+
+	dir := filepath.Join(os.TempDir(), fmt.Sprintf("sway-yasm-%d", os.Getuid()))
+	_ = os.MkdirAll(dir, 0700)
+
+	return dir
+}
+
+// listenRPC creates the RPC socket, replacing a stale one. Fails when another
+// daemon already listens on it.
+func listenRPC(path string) (*net.UnixListener, error) {
+	if _, err := os.Stat(path); err == nil {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("daemon already running on %s", path)
+		}
+		// stale
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// peerListener accepts only connections from processes of the same user.
+type peerListener struct {
+	*net.UnixListener
+	logger *log.Logger
+}
+
+func (l *peerListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.AcceptUnix()
+		if err != nil {
+			return nil, err
+		}
+
+		uid, err := peerUID(conn)
+		if err == nil && uid == os.Getuid() {
+			return conn, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("UID %d not allowed", uid)
+		}
+		l.logger.Printf("rejected RPC connection: %s", err)
+		conn.Close()
+	}
+}
@@ -0,0 +1,100 @@
+package daemon
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/pancsta/sway-yasm/internal/config"
+	"github.com/pancsta/sway-yasm/internal/types"
+)
+
+// state holds everything mutable in the daemon. It's owned by the state loop
+// and should only be accessed via Daemon.exec.
+type state struct {
+	winFocus WindowFocus
+	winData  map[string]types.WindowData
+	spaces   map[string]types.SpaceData
+	// workspace IDs in the MRU order
+	spaceFocus []string
+	// ID of the focused workspace
+	focusedSpace string
+	// current binding mode
+	mode string
+	// pickers are the shown pickers, see RemotePickerShow
+	pickers   pickerSessions
+	pickerSeq int
+	// warm is the picker parked in the scratchpad, if any
+	warm *warmPicker
+	// current mouse output
+	mouseInOutput string
+	// cfg is replaced as a whole on each change, never modified
+	cfg *config.Config
+}
+
+func newState(cfg *config.Config) *state {
+	return &state{
+		winData: make(map[string]types.WindowData),
+		spaces:  make(map[string]types.SpaceData),
+		pickers: make(pickerSessions),
+		cfg:     cfg,
+	}
+}
+
+// focusedWindow returns the data of the most recently focused window.
+func (s *state) focusedWindow() types.WindowData {
+	if len(s.winFocus) < 1 {
+		return types.WindowData{}
+	}
+
+	return s.winData[s.winFocus[0]]
+}
+
+// prevSpace returns the data of the previously focused workspace.
+func (s *state) prevSpace() types.SpaceData {
+	if len(s.spaceFocus) < 2 {
+		return types.SpaceData{}
+	}
+
+	return s.spaces[s.spaceFocus[1]]
+}
+
+// unshift moves the ID to the front of the MRU list, trimming it to the
+// configured length. Returns the removed IDs.
+func (s *state) unshift(ids []string, id string) ([]string, []string) {
+	return unshiftAndTrim(ids, id, s.cfg.Daemon.MaxTracked)
+}
+
+// clone returns a copy of the state, sharing only the immutable config.
+func (s *state) clone() *state {
+	cp := *s
+	cp.winFocus = slices.Clone(s.winFocus)
+	cp.winData = maps.Clone(s.winData)
+	cp.spaces = maps.Clone(s.spaces)
+	cp.spaceFocus = slices.Clone(s.spaceFocus)
+	cp.pickers = maps.Clone(s.pickers)
+
+	return &cp
+}
+
+// snapshot returns a copy of the windows' data in the MRU order.
+func (s *state) snapshot() (WindowFocus, map[string]types.WindowData) {
+	return slices.Clone(s.winFocus), maps.Clone(s.winData)
+}
+
+// loop serializes all the state access. It never exits.
+func (d *Daemon) loop() {
+	for fn := range d.queue {
+		fn()
+	}
+}
+
+// exec runs fn within the state loop and waits for it to finish. fn should
+// not call any exported Daemon methods, as those call exec themselves.
+func (d *Daemon) exec(fn func(s *state)) {
+	done := make(chan struct{})
+	d.queue <- func() {
+		defer close(done)
+		fn(d.state)
+	}
+	<-done
+}
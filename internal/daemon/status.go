@@ -0,0 +1,94 @@
+package daemon
+
+import (
+	"os"
+	"runtime/debug"
+	"slices"
+	"time"
+
+	"github.com/samber/lo"
+
+	"github.com/pancsta/sway-yasm/internal/config"
+	ss "github.com/pancsta/sway-yasm/internal/watcher/states"
+	usrCmds "github.com/pancsta/sway-yasm/pkg/usr-cmds"
+)
+
+// Status describes a running daemon, see RemoteStatus.
+type Status struct {
+	PID       int       `json:"pid"`
+	Version   string    `json:"version"`
+	StartedAt time.Time `json:"started_at"`
+	SwaySock  string    `json:"sway_sock"`
+	Connected bool      `json:"connected"`
+	// DryRun means sway commands only get simulated, see Daemon.DryRun
+	DryRun bool `json:"dry_run"`
+	// tracked counts
+	Windows    int `json:"windows"`
+	Workspaces int `json:"workspaces"`
+	Outputs    int `json:"outputs"`
+	// PathReady is true after the PATH index got refreshed
+	PathReady         bool           `json:"path_ready"`
+	Clipman           bool           `json:"clipman"`
+	MouseFollowsFocus bool           `json:"mouse_follows_focus"`
+	UsrCmds           []string       `json:"usr_cmds"`
+	Listeners         map[string]int `json:"listeners"`
+	Subscribers       int            `json:"subscribers"`
+}
+
+// Healthy returns true when the daemon is connected to sway.
+func (s *Status) Healthy() bool {
+	return s.Connected
+}
+
+// Version returns the module version of the binary.
+func Version() string {
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+
+	return build.Main.Version
+}
+
+// RemoteStatus is an RPC method
+func (d *Daemon) RemoteStatus(_ Empty, reply *StatusReply) error {
+	status := Status{
+		PID:         os.Getpid(),
+		Version:     Version(),
+		StartedAt:   d.startedAt,
+		SwaySock:    os.Getenv("SWAYSOCK"),
+		DryRun:      d.DryRun,
+		PathReady:   d.watcher.Mach.Is1(ss.AllRefreshed),
+		Clipman:     isClipmanRunning(),
+		UsrCmds:     lo.Keys(usrCmds.Registered),
+		Listeners:   map[string]int{},
+		Subscribers: d.events.count(),
+	}
+	slices.Sort(status.UsrCmds)
+	for event, listeners := range usrCmds.Listeners {
+		status.Listeners[event] = len(listeners)
+	}
+	_, err := d.sway()
+	status.Connected = err == nil
+
+	var cfg *config.Config
+	outputs := map[string]bool{}
+	d.exec(func(st *state) {
+		cfg = st.cfg
+		status.Windows = len(st.winData)
+		for _, space := range st.spaces {
+			// skip the scratchpad
+			if space.Name == "__i3_scratch" {
+				continue
+			}
+			status.Workspaces++
+			outputs[space.Output] = true
+		}
+	})
+	status.Outputs = len(outputs)
+	status.MouseFollowsFocus = cfg.Daemon.MouseFollowsFocus
+	reply.Status = status
+	reply.Config = cfg
+
+	return nil
+}
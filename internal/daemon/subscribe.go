@@ -0,0 +1,353 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pancsta/sway-yasm/internal/types"
+)
+
+// EventTypes are the types of events published to subscribers.
+var EventTypes = []string{
+	EventMRU,
+	EventWindowFocus, EventWindowNew, EventWindowClose, EventWindowTitle,
+	EventSpaceFocus,
+	EventOutput,
+	EventConfig,
+	EventClipboard,
+	EventUsrCmd,
+	EventPathRefresh,
+	EventPicker,
+}
+
+const (
+	// EventMRU means the MRU order of windows changed
+	EventMRU         = "mru"
+	EventWindowFocus = "window_focus"
+	EventWindowNew   = "window_new"
+	EventWindowClose = "window_close"
+	EventWindowTitle = "window_title"
+	EventSpaceFocus  = "workspace_focus"
+	// EventOutput means outputs got (dis)connected or reconfigured
+	EventOutput = "output"
+	// EventConfig means the config changed, via the file or RPC
+	EventConfig = "config"
+	// EventClipboard is a copy via RemoteCopy
+	EventClipboard = "clipboard"
+	EventUsrCmd    = "usr_cmd"
+	// EventPathRefresh means the PATH index got refreshed
+	EventPathRefresh = "path_refresh"
+	// EventPicker is an action for a shown picker, see PickerCycle and
+	// PickerClose
+	EventPicker = "picker"
+	// EventDropped is sent to a slow subscriber after its events got dropped
+	EventDropped = "dropped"
+)
+
+// actions of EventPicker
+const (
+	// PickerCycle selects the next row
+	PickerCycle = "cycle"
+	PickerClose = "close"
+)
+
+// subscriberBuffer is the number of events queued per subscriber, before
+// dropping new ones.
+const subscriberBuffer = 256
+
+// Event is a high-level event of the daemon, encoded as a single line of
+// JSON.
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// Window for window_*
+	Window *types.WindowData `json:"window,omitempty"`
+	// Workspace for workspace_focus
+	Workspace *types.SpaceData `json:"workspace,omitempty"`
+	// MRU is a list of window IDs for mru
+	MRU []int `json:"mru,omitempty"`
+	// Outputs are names of the outputs with workspaces, for output
+	Outputs []string `json:"outputs,omitempty"`
+	// Text for clipboard
+	Text string `json:"text,omitempty"`
+	// UsrCmd and Args for usr_cmd
+	UsrCmd string `json:"usr_cmd,omitempty"`
+	Args   string `json:"args,omitempty"`
+	// Count of executables for path_refresh, or of dropped events
+	Count int `json:"count,omitempty"`
+	// Picker, Session and Action for picker
+	Picker  string `json:"picker,omitempty"`
+	Session int    `json:"session,omitempty"`
+	Action  string `json:"action,omitempty"`
+}
+
+// eventBus fans out events to subscribers, without ever blocking the
+// publisher.
+type eventBus struct {
+	mx   sync.Mutex
+	subs map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	// kinds is a filter, empty means all
+	kinds   []string
+	events  chan Event
+	dropped atomic.Int64
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subs: map[*subscriber]struct{}{}}
+}
+
+// publish passes the event to all the matching subscribers. Full buffers drop
+// the event.
+func (b *eventBus) publish(e Event) {
+	if b == nil {
+		return
+	}
+	e.Time = time.Now()
+
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	for sub := range b.subs {
+		if len(sub.kinds) > 0 && !slices.Contains(sub.kinds, e.Type) {
+			continue
+		}
+		select {
+		case sub.events <- e:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+func (b *eventBus) subscribe(kinds []string) *subscriber {
+	sub := &subscriber{
+		kinds:  kinds,
+		events: make(chan Event, subscriberBuffer),
+	}
+	b.mx.Lock()
+	b.subs[sub] = struct{}{}
+	b.mx.Unlock()
+
+	return sub
+}
+
+func (b *eventBus) unsubscribe(sub *subscriber) {
+	b.mx.Lock()
+	delete(b.subs, sub)
+	b.mx.Unlock()
+}
+
+// count returns the number of subscribers.
+func (b *eventBus) count() int {
+	if b == nil {
+		return 0
+	}
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	return len(b.subs)
+}
+
+// parseEventTypes validates a comma separated list of event types.
+func parseEventTypes(list []string) ([]string, error) {
+	var ret []string
+	for _, item := range list {
+		for _, t := range strings.Split(item, ",") {
+			t = strings.TrimSpace(t)
+			if t == "" {
+				continue
+			}
+			if !slices.Contains(EventTypes, t) {
+				return nil, fmt.Errorf("unknown event type %q, expected one of %s",
+					t, strings.Join(EventTypes, ", "))
+			}
+			ret = append(ret, t)
+		}
+	}
+
+	return ret, nil
+}
+
+// mruEvent returns the MRU event, if the order changed.
+func mruEvent(prev, next WindowFocus) (Event, bool) {
+	if slices.Equal(prev, next) {
+		return Event{}, false
+	}
+	e := Event{Type: EventMRU, MRU: []int{}}
+	for _, id := range next {
+		if num, err := strconv.Atoi(id); err == nil {
+			e.MRU = append(e.MRU, num)
+		}
+	}
+
+	return e, true
+}
+
+// stateEvents returns the events caused by a sway event, once the state got
+// updated. win and ok are the results of onEvent.
+func stateEvents(
+	st *state, e *swayEvent, win types.WindowData, ok bool, prevFocus WindowFocus,
+) []Event {
+	var events []Event
+
+	switch e.Type {
+
+	case eventWindow:
+		typ := map[string]string{
+			"focus": EventWindowFocus,
+			"new":   EventWindowNew,
+			"close": EventWindowClose,
+			"title": EventWindowTitle,
+		}[e.Change]
+		if ok && typ != "" {
+			events = append(events, Event{Type: typ, Window: &win})
+		}
+
+	case eventWorkspace:
+		if e.Change == "focus" {
+			space := st.spaces[st.focusedSpace]
+			events = append(events, Event{Type: EventSpaceFocus, Workspace: &space})
+		}
+
+	case eventOutput:
+		var outputs []string
+		for _, space := range st.spaces {
+			if !slices.Contains(outputs, space.Output) {
+				outputs = append(outputs, space.Output)
+			}
+		}
+		slices.Sort(outputs)
+		events = append(events, Event{Type: EventOutput, Outputs: outputs})
+	}
+
+	if mru, ok := mruEvent(prevFocus, st.winFocus); ok {
+		events = append(events, mru)
+	}
+
+	return events
+}
+
+// ///// ///// /////
+// ///// HTTP
+// ///// ///// /////
+
+// serveEvents streams the events as NDJSON until the client disconnects.
+func (h *jsonHandler) serveEvents(w http.ResponseWriter, r *http.Request) {
+	kinds, err := parseEventTypes(r.URL.Query()["type"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	sub := h.d.events.subscribe(kinds)
+	defer h.d.events.unsubscribe(sub)
+	log.Printf("subscribed to %v", kinds)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-sub.events:
+			err = enc.Encode(e)
+			// events get dropped only when the buffer is full, so report the
+			// gap once it's drained
+			if n := sub.dropped.Load(); err == nil && n > 0 &&
+				len(sub.events) == 0 {
+
+				sub.dropped.Add(-n)
+				err = enc.Encode(Event{Type: EventDropped, Time: time.Now(),
+					Count: int(n)})
+			}
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// ///// ///// /////
+// ///// CLIENT
+// ///// ///// /////
+
+// Subscribe streams the daemon's events of the passed types (all when empty)
+// to out, as NDJSON, until ctx is done or the daemon goes away.
+func Subscribe(ctx context.Context, kinds []string, out io.Writer) error {
+	kinds, err := parseEventTypes(kinds)
+	if err != nil {
+		return err
+	}
+
+	clientMx.Lock()
+	if clientCfg == nil {
+		clientCfg = clientConfig()
+	}
+	cfg := clientCfg
+	clientMx.Unlock()
+
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(context.Context, string, string) (net.Conn, error) {
+				return dial(cfg)
+			},
+		},
+	}
+	query := url.Values{}
+	if len(kinds) > 0 {
+		query.Set("type", strings.Join(kinds, ","))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		"http://sway-yasm/events?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return &MethodError{Method: "events", Msg: strings.TrimSpace(string(msg))}
+	}
+
+	_, err = io.Copy(out, resp.Body)
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err == nil {
+		err = fmt.Errorf("%w: the daemon closed the stream", io.ErrUnexpectedEOF)
+	}
+
+	return err
+}
@@ -0,0 +1,241 @@
+package daemon
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/pancsta/gosway/ipc"
+)
+
+// ErrSwayUnavailable is returned by methods requiring IPC while the daemon is
+// (re)connecting to sway.
+var ErrSwayUnavailable = errors.New("sway unavailable")
+
+// errSwayShutdown ends the daemon after sway exits.
+var errSwayShutdown = errors.New("sway shutdown")
+
+// event types (without the high bit)
+const (
+	eventWorkspace = 0
+	eventOutput    = 1
+	eventMode      = 2
+	eventWindow    = 3
+	eventShutdown  = 6
+)
+
+var eventNames = map[int]string{
+	eventWorkspace: "workspace",
+	eventOutput:    "output",
+	eventMode:      "mode",
+	eventWindow:    "window",
+	eventShutdown:  "shutdown",
+}
+
+// swayEvent is a decoded IPC event of any of the subscribed types. Container
+// is set for window events, Current and Old for workspace events.
+type swayEvent struct {
+	Type      int            `json:"-"`
+	Change    string         `json:"change"`
+	Container ipc.Container  `json:"container"`
+	Current   *swayWorkspace `json:"current"`
+	Old       *swayWorkspace `json:"old"`
+}
+
+// swayWorkspace is the part of a workspace node used by the daemon.
+type swayWorkspace struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Output string `json:"output"`
+}
+
+// compositor is the part of the sway IPC used by the daemon. It's implemented
+// by swayCompositor, and by memCompositor for running without sway.
+type compositor interface {
+	GetTree() (*ipc.Tree, error)
+	GetWorkspaces() ([]*ipc.Workspace, error)
+	// RunCommand runs the command(s) and returns the first failure
+	RunCommand(cmd string) error
+	// Subscribe opens an event stream of the passed types, independent of
+	// the other requests
+	Subscribe(events []string) (*subscription, error)
+	Close() error
+}
+
+// subscription reads events from a dedicated sway IPC connection. Unlike
+// ipc.Subscribe, it can be closed at any time, without leaking goroutines.
+type subscription struct {
+	// conn is nil for in-memory subscriptions
+	conn   net.Conn
+	Events chan swayEvent
+	Errors chan error
+	done   chan struct{}
+}
+
+// msgRunCommand is the RUN_COMMAND IPC message type.
+const msgRunCommand = 0
+
+// swayCompositor talks to sway over IPC.
+type swayCompositor struct {
+	conn *ipc.SwayConnection
+}
+
+var _ compositor = &swayCompositor{}
+
+// dialSway opens an IPC connection to sway.
+func dialSway() (compositor, error) {
+	conn, err := dialSocket()
+	if err != nil {
+		return nil, err
+	}
+
+	return &swayCompositor{conn: &ipc.SwayConnection{Conn: conn}}, nil
+}
+
+// dialSocket connects to SWAYSOCK, falling back to asking sway for the socket
+// path. Unlike ipc.NewSwayConnection, it doesn't require the sway binary, so
+// SWAYSOCK can point to any IPC server (see swaytest).
+func dialSocket() (net.Conn, error) {
+	path := os.Getenv("SWAYSOCK")
+	if path == "" {
+		var err error
+		path, err = ipc.GetSocketPath()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return net.Dial("unix", path)
+}
+
+func (c *swayCompositor) GetTree() (*ipc.Tree, error) {
+	return c.conn.GetTree()
+}
+
+func (c *swayCompositor) GetWorkspaces() ([]*ipc.Workspace, error) {
+	return c.conn.GetWorkspaces()
+}
+
+// RunCommand runs the command(s) via IPC, instead of swaymsg.
+func (c *swayCompositor) RunCommand(cmd string) error {
+	out, err := c.conn.SendCommand(msgRunCommand, cmd)
+	if err != nil {
+		return err
+	}
+	var results []struct {
+		Success bool   `json:"success"`
+		Error   string `json:"error"`
+	}
+	if err := json.Unmarshal(out, &results); err != nil {
+		return err
+	}
+	for _, res := range results {
+		if !res.Success {
+			return fmt.Errorf("sway: %s: %s", cmd, res.Error)
+		}
+	}
+
+	return nil
+}
+
+// Subscribe opens a new IPC connection and subscribes to the passed event
+// types.
+func (c *swayCompositor) Subscribe(events []string) (*subscription, error) {
+	nc, err := dialSocket()
+	if err != nil {
+		return nil, err
+	}
+	conn := &ipc.SwayConnection{Conn: nc}
+
+	payload, err := json.Marshal(events)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	_, err = conn.SendCommand(ipc.IPC_SUBSCRIBE, string(payload))
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	s := newSubscription(nc)
+	go s.readLoop()
+
+	return s, nil
+}
+
+func (c *swayCompositor) Close() error {
+	return c.conn.Conn.Close()
+}
+
+func newSubscription(conn net.Conn) *subscription {
+	return &subscription{
+		conn:   conn,
+		Events: make(chan swayEvent),
+		Errors: make(chan error, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+func (s *subscription) readLoop() {
+	header := make([]byte, ipc.HEADERLEN)
+	for {
+		if _, err := io.ReadFull(s.conn, header); err != nil {
+			s.Errors <- err
+			return
+		}
+		if string(header[:len(ipc.MAGICK)]) != ipc.MAGICK {
+			s.Errors <- fmt.Errorf("invalid magic string: %q",
+				header[:len(ipc.MAGICK)])
+			return
+		}
+		length := binary.NativeEndian.Uint32(header[len(ipc.MAGICK):])
+		msgType := binary.NativeEndian.Uint32(header[len(ipc.MAGICK)+4:])
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(s.conn, payload); err != nil {
+			s.Errors <- err
+			return
+		}
+
+		// skip unknown events
+		msgType &^= 1 << 31
+		if _, ok := eventNames[int(msgType)]; !ok {
+			continue
+		}
+
+		event := swayEvent{Type: int(msgType)}
+		if err := json.Unmarshal(payload, &event); err != nil {
+			s.Errors <- err
+			return
+		}
+		select {
+		case s.Events <- event:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Close closes the underlying connection, which ends the read loop.
+func (s *subscription) Close() {
+	close(s.done)
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
+
+// backoff returns the delay before the next reconnection attempt.
+func backoff(attempt int, min, max time.Duration) time.Duration {
+	delay := min << attempt
+	if delay > max || delay <= 0 {
+		return max
+	}
+
+	return delay
+}
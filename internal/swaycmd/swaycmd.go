@@ -0,0 +1,81 @@
+// Package swaycmd tokenizes sway commands, as sent over IPC, eg
+// `[con_id=1] move to workspace "1: main"; focus`. Shared by the daemon's dry
+// run and swaytest.
+package swaycmd
+
+import (
+	"slices"
+	"strings"
+)
+
+// Split splits the payload on sep outside of quotes and criteria. Sway
+// separates commands with ";" and ",", where criteria apply until the next
+// ";".
+func Split(payload string, sep rune) []string {
+	var ret []string
+	var quote rune
+	brackets := false
+	start := 0
+	for i, r := range payload {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '[':
+			brackets = true
+		case r == ']':
+			brackets = false
+		case r == sep && !brackets:
+			ret = append(ret, payload[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(ret, payload[start:])
+}
+
+// Fields splits the command into words, unquoting quoted ones.
+func Fields(cmd string) []string {
+	var ret []string
+	var word strings.Builder
+	var quote rune
+	inWord := false
+	for _, r := range cmd {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				ret = append(ret, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if inWord {
+		ret = append(ret, word.String())
+	}
+
+	return ret
+}
+
+// Skip drops the leading optional words, eg "container" of
+// "move container to workspace 1".
+func Skip(args []string, optional ...string) []string {
+	for len(args) > 0 && slices.Contains(optional, args[0]) {
+		args = args[1:]
+	}
+
+	return args
+}
@@ -0,0 +1,75 @@
+package swaycmd
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		payload string
+		sep     rune
+		want    []string
+	}{
+		{"focus", ';', []string{"focus"}},
+		{"focus; kill", ';', []string{"focus", " kill"}},
+		{"[con_id=1] focus, kill; border none", ';',
+			[]string{"[con_id=1] focus, kill", " border none"}},
+		{"[con_id=1] focus, kill", ',', []string{"[con_id=1] focus", " kill"}},
+		{`[title="a;b"] focus; kill`, ';',
+			[]string{`[title="a;b"] focus`, " kill"}},
+		{`workspace "1; 2"; kill`, ';', []string{`workspace "1; 2"`, " kill"}},
+		{`exec 'a, b', kill`, ',', []string{`exec 'a, b'`, " kill"}},
+		{"", ';', []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.payload, func(t *testing.T) {
+			if got := Split(tt.payload, tt.sep); !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFields(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want []string
+	}{
+		{"focus", []string{"focus"}},
+		{"  move  to\tworkspace 1 ", []string{"move", "to", "workspace", "1"}},
+		{`workspace "1: main"`, []string{"workspace", "1: main"}},
+		{`workspace '1: "main"'`, []string{"workspace", `1: "main"`}},
+		{`workspace a"b c"d`, []string{"workspace", "ab cd"}},
+		{`workspace ""`, []string{"workspace", ""}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cmd, func(t *testing.T) {
+			if got := Fields(tt.cmd); !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSkip(t *testing.T) {
+	args := []string{"container", "to", "workspace", "to"}
+	tests := []struct {
+		name     string
+		optional []string
+		want     []string
+	}{
+		{"none", nil, args},
+		{"one", []string{"container"}, args[1:]},
+		{"many", []string{"to", "container"}, args[2:]},
+		{"only leading", []string{"to"}, args},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Skip(args, tt.optional...); !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package swaytest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pancsta/sway-yasm/internal/swaycmd"
+)
+
+// commandResult is a single element of the RUN_COMMAND reply.
+type commandResult struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// noopCommands get accepted without changing the tree.
+var noopCommands = []string{
+	"exec", "exec_always", "for_window", "input", "bindsym", "bindcode",
+	"floating", "sticky", "default_border", "gaps", "mark", "unmark",
+}
+
+// run applies the commands, separated by ";" or ",", as sway does. Criteria
+// apply to the commands until the next ";". Requires mx.
+func (s *Server) run(t *tree, payload string) []commandResult {
+	var results []commandResult
+
+	for _, group := range swaycmd.Split(payload, ';') {
+		var target *Window
+		criteria := false
+		for _, cmd := range swaycmd.Split(group, ',') {
+			cmd = strings.TrimSpace(cmd)
+			if cmd == "" {
+				continue
+			}
+			s.commands = append(s.commands, cmd)
+
+			if strings.HasPrefix(cmd, "[") {
+				end := strings.Index(cmd, "]")
+				if end < 0 {
+					results = append(results, fail("invalid criteria: %s", cmd))
+					continue
+				}
+				win, err := matchCriteria(t, cmd[1:end])
+				if err != nil {
+					results = append(results, fail("%s", err))
+					continue
+				}
+				target = win
+				criteria = true
+				cmd = strings.TrimSpace(cmd[end+1:])
+			}
+			if criteria && target == nil {
+				results = append(results, fail("No matching node"))
+				continue
+			}
+
+			win := target
+			if !criteria && t.focusedWin != 0 {
+				win, _ = t.window(t.focusedWin)
+			}
+			if err := s.runCommand(t, win, cmd); err != nil {
+				results = append(results, fail("%s", err))
+				continue
+			}
+			results = append(results, commandResult{Success: true})
+		}
+	}
+
+	return results
+}
+
+// runCommand applies a single command to win, which is the criteria match or
+// the focused window (or nil).
+func (s *Server) runCommand(t *tree, win *Window, cmd string) error {
+	args := swaycmd.Fields(cmd)
+	if len(args) == 0 {
+		return nil
+	}
+	needWin := func() error {
+		if win == nil {
+			return fmt.Errorf("no window to %s", args[0])
+		}
+		return nil
+	}
+
+	switch args[0] {
+
+	case "focus":
+		if err := needWin(); err != nil {
+			return err
+		}
+		s.focusWindow(t, win)
+		return nil
+
+	case "workspace":
+		args = swaycmd.Skip(args[1:], "--no-auto-back-and-forth", "number")
+		if len(args) == 0 {
+			return fmt.Errorf("expected a workspace name")
+		}
+		return s.focusWorkspace(t, strings.Join(args, " "))
+
+	case "move":
+		args = swaycmd.Skip(args[1:], "container", "window")
+		if len(args) >= 2 && args[0] == "workspace" &&
+			(args[1] == "to" || args[1] == "output") {
+
+			args = swaycmd.Skip(args[1:], "to", "output")
+			if len(args) != 1 {
+				return fmt.Errorf("expected an output name")
+			}
+			return s.moveWorkspace(t, args[0])
+		}
+		args = swaycmd.Skip(args, "to")
+		if len(args) == 1 && args[0] == "scratchpad" {
+			if err := needWin(); err != nil {
+				return err
+			}
+			s.moveWindow(t, win, scratchName)
+			return nil
+		}
+		// floating windows only
+		if len(args) >= 1 && args[0] == "position" {
+			return needWin()
+		}
+		if len(args) < 2 || args[0] != "workspace" {
+			return fmt.Errorf("unsupported move: %s", cmd)
+		}
+		args = swaycmd.Skip(args[1:], "--no-auto-back-and-forth", "number")
+		if err := needWin(); err != nil {
+			return err
+		}
+		s.moveWindow(t, win, strings.Join(args, " "))
+		return nil
+
+	case "scratchpad":
+		if len(args) != 2 || args[1] != "show" {
+			return fmt.Errorf("only scratchpad show is supported")
+		}
+		if err := needWin(); err != nil {
+			return err
+		}
+		if _, ws := t.window(win.ID); ws.name != scratchName || t.focused == nil {
+			return nil
+		}
+		s.moveWindow(t, win, t.focused.name)
+		s.focusWindow(t, win)
+		return nil
+
+	case "border":
+		if err := needWin(); err != nil {
+			return err
+		}
+		if len(args) < 2 {
+			return fmt.Errorf("expected a border style")
+		}
+		switch args[1] {
+		case "none", "normal", "pixel", "csd":
+			win.Border = args[1]
+		case "toggle":
+			next := map[string]string{"none": "normal", "normal": "pixel",
+				"pixel": "none", "csd": "none"}
+			win.Border = next[win.Border]
+		default:
+			return fmt.Errorf("unknown border style %s", args[1])
+		}
+		return nil
+
+	case "resize":
+		if err := needWin(); err != nil {
+			return err
+		}
+		return resize(win, args[1:])
+
+	case "kill":
+		if err := needWin(); err != nil {
+			return err
+		}
+		return s.closeWindow(t, win.ID)
+	}
+
+	for _, noop := range noopCommands {
+		if args[0] == noop {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown command %s", args[0])
+}
+
+// resize handles "set [width] N [px|ppt] [[height] N [px|ppt]]", where ppt is
+// relative to the output.
+func resize(win *Window, args []string) error {
+	if len(args) == 0 || args[0] != "set" {
+		return fmt.Errorf("only resize set is supported")
+	}
+	args = args[1:]
+
+	dims := []*int{&win.Rect.Width, &win.Rect.Height}
+	full := []int{outputWidth, outputHeight}
+	dim := 0
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "width":
+			dim = 0
+			continue
+		case "height":
+			dim = 1
+			continue
+		}
+		if dim > 1 {
+			return fmt.Errorf("too many sizes")
+		}
+		size, err := strconv.Atoi(args[i])
+		if err != nil {
+			return fmt.Errorf("invalid size %s", args[i])
+		}
+		if i+1 < len(args) && (args[i+1] == "px" || args[i+1] == "ppt") {
+			if args[i+1] == "ppt" {
+				size = full[dim] * size / 100
+			}
+			i++
+		}
+		*dims[dim] = size
+		dim++
+	}
+
+	return nil
+}
+
+// matchCriteria returns the window matching con_id, or nil.
+func matchCriteria(t *tree, criteria string) (*Window, error) {
+	key, val, ok := strings.Cut(strings.TrimSpace(criteria), "=")
+	if !ok || key != "con_id" {
+		return nil, fmt.Errorf("unsupported criteria: %s", criteria)
+	}
+	id, err := strconv.Atoi(strings.Trim(val, `"'`))
+	if err != nil {
+		return nil, fmt.Errorf("invalid con_id: %s", val)
+	}
+	win, _ := t.window(id)
+
+	return win, nil
+}
+
+// ///// ///// /////
+// ///// HELPERS
+// ///// ///// /////
+
+func fail(format string, args ...any) commandResult {
+	return commandResult{Error: fmt.Sprintf(format, args...)}
+}
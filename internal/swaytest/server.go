@@ -0,0 +1,311 @@
+// Package swaytest provides a stand-in sway server for integration tests. It
+// speaks the i3/sway IPC protocol over a unix socket, holds a scriptable tree,
+// applies a subset of commands and emits events, so the daemon can run with
+// SWAYSOCK pointed at it, without a compositor.
+package swaytest
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/pancsta/gosway/ipc"
+)
+
+// message types
+const (
+	msgRunCommand    = 0
+	msgGetWorkspaces = ipc.IPC_GET_WORKSPACES
+	msgSubscribe     = ipc.IPC_SUBSCRIBE
+	msgGetOutputs    = ipc.IPC_GET_OUTPUTS
+	msgGetTree       = ipc.IPC_GET_TREE
+	msgGetVersion    = ipc.IPC_GET_VERSION
+)
+
+// eventTypes are the IPC event types, without the high bit.
+var eventTypes = map[string]uint32{
+	"workspace": 0,
+	"output":    1,
+	"mode":      2,
+	"window":    3,
+	"shutdown":  6,
+}
+
+// Server is a fake sway IPC server. All the methods are safe for concurrent
+// use. Methods changing the tree emit the same events as sway would.
+type Server struct {
+	// Path is the socket, to be used as SWAYSOCK
+	Path string
+
+	listener net.Listener
+	dir      string
+
+	// emitMx keeps the order of events across changes
+	emitMx sync.Mutex
+	// mx guards the tree and the fields below
+	mx          sync.Mutex
+	tree        *tree
+	commands    []string
+	subscribers map[*conn][]string
+	pending     []event
+	closed      bool
+}
+
+type conn struct {
+	net.Conn
+	// writeMx serializes replies and events
+	writeMx sync.Mutex
+}
+
+type event struct {
+	typ     string
+	payload []byte
+}
+
+// NewServer starts a server with an empty tree, listening on a socket in a
+// new temp dir.
+func NewServer() (*Server, error) {
+	dir, err := os.MkdirTemp("", "swaytest")
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, "sway-ipc.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	s := &Server{
+		Path:        path,
+		listener:    l,
+		dir:         dir,
+		tree:        newTree(),
+		subscribers: map[*conn][]string{},
+	}
+	go s.accept()
+
+	return s, nil
+}
+
+// Close stops the server and removes the socket.
+func (s *Server) Close() error {
+	s.mx.Lock()
+	s.closed = true
+	for c := range s.subscribers {
+		c.Close()
+	}
+	s.mx.Unlock()
+
+	err := s.listener.Close()
+	os.RemoveAll(s.dir)
+
+	return err
+}
+
+// Commands returns all the commands received so far, split into single
+// commands.
+func (s *Server) Commands() []string {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return slices.Clone(s.commands)
+}
+
+// Emit sends a raw event of the passed type ("window", "workspace", "output",
+// "mode" or "shutdown") to the subscribers.
+func (s *Server) Emit(eventType string, payload any) error {
+	if _, ok := eventTypes[eventType]; !ok {
+		return fmt.Errorf("unknown event type %s", eventType)
+	}
+
+	return s.update(func(t *tree) error {
+		s.emit(eventType, payload)
+		return nil
+	})
+}
+
+// Shutdown sends the shutdown event, which ends the daemon.
+func (s *Server) Shutdown() error {
+	return s.Emit("shutdown", map[string]string{"change": "exit"})
+}
+
+// update runs fn on the tree and sends the events emitted by it, in order.
+func (s *Server) update(fn func(t *tree) error) error {
+	s.emitMx.Lock()
+	defer s.emitMx.Unlock()
+
+	s.mx.Lock()
+	err := fn(s.tree)
+	events := s.pending
+	s.pending = nil
+	subs := map[*conn][]string{}
+	for c, types := range s.subscribers {
+		subs[c] = types
+	}
+	s.mx.Unlock()
+
+	for _, e := range events {
+		for c, types := range subs {
+			if slices.Contains(types, e.typ) {
+				// a broken subscriber gets removed by its read loop
+				_ = c.write(eventTypes[e.typ]|1<<31, e.payload)
+			}
+		}
+	}
+
+	return err
+}
+
+// emit queues an event, to be sent by update. Requires mx.
+func (s *Server) emit(eventType string, payload any) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		panic(err)
+	}
+	s.pending = append(s.pending, event{typ: eventType, payload: data})
+}
+
+// ///// ///// /////
+// ///// PROTOCOL
+// ///// ///// /////
+
+func (s *Server) accept() {
+	for {
+		nc, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.serve(&conn{Conn: nc})
+	}
+}
+
+func (s *Server) serve(c *conn) {
+	defer func() {
+		s.mx.Lock()
+		delete(s.subscribers, c)
+		s.mx.Unlock()
+		c.Close()
+	}()
+
+	for {
+		msgType, payload, err := c.read()
+		if err != nil {
+			return
+		}
+		reply, err := s.handle(c, msgType, payload)
+		if err != nil {
+			reply, _ = json.Marshal(map[string]any{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+		if err := c.write(msgType, reply); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) handle(c *conn, msgType uint32, payload []byte) ([]byte, error) {
+	switch msgType {
+
+	case msgRunCommand:
+		var results []commandResult
+		err := s.update(func(t *tree) error {
+			results = s.run(t, string(payload))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(results)
+
+	case msgSubscribe:
+		var types []string
+		if err := json.Unmarshal(payload, &types); err != nil {
+			return nil, err
+		}
+		for _, typ := range types {
+			if _, ok := eventTypes[typ]; !ok {
+				return nil, fmt.Errorf("unsupported event type %s", typ)
+			}
+		}
+		s.mx.Lock()
+		if s.closed {
+			s.mx.Unlock()
+			return nil, errors.New("server closed")
+		}
+		s.subscribers[c] = append(s.subscribers[c], types...)
+		s.mx.Unlock()
+		return json.Marshal(map[string]bool{"success": true})
+
+	case msgGetTree:
+		s.mx.Lock()
+		defer s.mx.Unlock()
+		return json.Marshal(s.tree.rootNode())
+
+	case msgGetWorkspaces:
+		s.mx.Lock()
+		defer s.mx.Unlock()
+		return json.Marshal(s.tree.workspaceList())
+
+	case msgGetOutputs:
+		s.mx.Lock()
+		defer s.mx.Unlock()
+		return json.Marshal(s.tree.outputList())
+
+	case msgGetVersion:
+		return json.Marshal(map[string]any{
+			"major":                   1,
+			"minor":                   9,
+			"patch":                   0,
+			"human_readable":          "swaytest",
+			"loaded_config_file_name": "",
+		})
+	}
+
+	return nil, fmt.Errorf("unsupported message type %d", msgType)
+}
+
+func (c *conn) read() (uint32, []byte, error) {
+	header := make([]byte, ipc.HEADERLEN)
+	if _, err := io.ReadFull(c, header); err != nil {
+		return 0, nil, err
+	}
+	if string(header[:len(ipc.MAGICK)]) != ipc.MAGICK {
+		return 0, nil, fmt.Errorf("invalid magic string: %q",
+			header[:len(ipc.MAGICK)])
+	}
+	length := binary.NativeEndian.Uint32(header[len(ipc.MAGICK):])
+	msgType := binary.NativeEndian.Uint32(header[len(ipc.MAGICK)+4:])
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c, payload); err != nil {
+		return 0, nil, err
+	}
+
+	return msgType, payload, nil
+}
+
+// write sends the header and the payload in a single write, as the gosway
+// client reads them with single reads.
+func (c *conn) write(msgType uint32, payload []byte) error {
+	msg := make([]byte, ipc.HEADERLEN, ipc.HEADERLEN+len(payload))
+	copy(msg, ipc.MAGICK)
+	binary.NativeEndian.PutUint32(msg[len(ipc.MAGICK):], uint32(len(payload)))
+	binary.NativeEndian.PutUint32(msg[len(ipc.MAGICK)+4:], msgType)
+	msg = append(msg, payload...)
+
+	c.writeMx.Lock()
+	defer c.writeMx.Unlock()
+	_, err := c.Write(msg)
+
+	return err
+}
@@ -0,0 +1,654 @@
+package swaytest
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/pancsta/gosway/ipc"
+)
+
+const (
+	outputWidth  = 1920
+	outputHeight = 1080
+	scratchName  = "__i3_scratch"
+)
+
+// Window is a view of the window, see Server.Window.
+type Window struct {
+	ID         int
+	AppID      string
+	Title      string
+	PID        int
+	Floating   bool
+	Fullscreen bool
+	Urgent     bool
+	// Border is "normal", "none", "pixel" or "csd"
+	Border    string
+	Rect      ipc.Rect
+	Workspace string
+}
+
+type workspace struct {
+	id      int
+	name    string
+	output  *output
+	windows []*Window
+	// focus is the order of the windows, most recently focused first
+	focus []int
+}
+
+type output struct {
+	id         int
+	name       string
+	rect       ipc.Rect
+	workspaces []*workspace
+	// current is the visible workspace
+	current *workspace
+}
+
+type tree struct {
+	nextID  int
+	outputs []*output
+	// scratch holds the scratchpad workspace, as in sway's "__i3" output
+	scratch *output
+	// focused is the focused workspace
+	focused *workspace
+	// focusedWin is the ID of the focused window, or 0
+	focusedWin int
+}
+
+func newTree() *tree {
+	t := &tree{nextID: 1}
+	t.scratch = &output{id: t.id(), name: "__i3"}
+	t.scratch.workspaces = []*workspace{{
+		id:     t.id(),
+		name:   scratchName,
+		output: t.scratch,
+	}}
+
+	return t
+}
+
+func (t *tree) id() int {
+	t.nextID++
+	return t.nextID
+}
+
+// ///// ///// /////
+// ///// SCRIPTING
+// ///// ///// /////
+
+// AddOutput adds an output, to the right of the existing ones.
+func (s *Server) AddOutput(name string) error {
+	return s.update(func(t *tree) error {
+		if t.output(name) != nil {
+			return fmt.Errorf("output %s exists", name)
+		}
+		t.outputs = append(t.outputs, &output{
+			id:   t.id(),
+			name: name,
+			rect: ipc.Rect{
+				X:      len(t.outputs) * outputWidth,
+				Width:  outputWidth,
+				Height: outputHeight,
+			},
+		})
+		s.emit("output", map[string]string{"change": "unspecified"})
+
+		return nil
+	})
+}
+
+// AddWorkspace adds a workspace to the output. The first workspace of an
+// output becomes visible and the first one overall gets focused.
+func (s *Server) AddWorkspace(outputName, name string) error {
+	return s.update(func(t *tree) error {
+		o := t.output(outputName)
+		if o == nil {
+			return fmt.Errorf("output %s not found", outputName)
+		}
+		if t.workspace(name) != nil {
+			return fmt.Errorf("workspace %s exists", name)
+		}
+		s.addWorkspace(t, o, name)
+
+		return nil
+	})
+}
+
+// AddWindow opens a window on the workspace and returns its ID. A window on
+// the focused workspace gets focused.
+func (s *Server) AddWindow(space, appID, title string) (int, error) {
+	var id int
+	err := s.update(func(t *tree) error {
+		ws := t.workspace(space)
+		if ws == nil {
+			return fmt.Errorf("workspace %s not found", space)
+		}
+		win := &Window{
+			ID:     t.id(),
+			AppID:  appID,
+			Title:  title,
+			PID:    10000 + t.nextID,
+			Border: "normal",
+		}
+		id = win.ID
+		ws.windows = append(ws.windows, win)
+		ws.focus = append(ws.focus, win.ID)
+		ws.layout()
+		s.emit("window", s.windowEvent(t, "new", win))
+
+		if ws == t.focused {
+			s.focusWindow(t, win)
+		}
+
+		return nil
+	})
+
+	return id, err
+}
+
+// Focus focuses the window, and its workspace.
+func (s *Server) Focus(id int) error {
+	return s.update(func(t *tree) error {
+		win, _ := t.window(id)
+		if win == nil {
+			return fmt.Errorf("window %d not found", id)
+		}
+		s.focusWindow(t, win)
+
+		return nil
+	})
+}
+
+// FocusWorkspace focuses the workspace, creating it on the focused output if
+// needed.
+func (s *Server) FocusWorkspace(name string) error {
+	return s.update(func(t *tree) error {
+		return s.focusWorkspace(t, name)
+	})
+}
+
+// CloseWindow closes the window, as if the app exited.
+func (s *Server) CloseWindow(id int) error {
+	return s.update(func(t *tree) error {
+		return s.closeWindow(t, id)
+	})
+}
+
+// SetTitle changes the window's title.
+func (s *Server) SetTitle(id int, title string) error {
+	return s.update(func(t *tree) error {
+		win, _ := t.window(id)
+		if win == nil {
+			return fmt.Errorf("window %d not found", id)
+		}
+		win.Title = title
+		s.emit("window", s.windowEvent(t, "title", win))
+
+		return nil
+	})
+}
+
+// Window returns a copy of the window.
+func (s *Server) Window(id int) (Window, bool) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	win, ws := s.tree.window(id)
+	if win == nil {
+		return Window{}, false
+	}
+	ret := *win
+	ret.Workspace = ws.name
+
+	return ret, true
+}
+
+// FocusedWindow returns the ID of the focused window, or 0.
+func (s *Server) FocusedWindow() int {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return s.tree.focusedWin
+}
+
+// FocusedWorkspace returns the name of the focused workspace.
+func (s *Server) FocusedWorkspace() string {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.tree.focused == nil {
+		return ""
+	}
+
+	return s.tree.focused.name
+}
+
+// ///// ///// /////
+// ///// CHANGES
+// ///// ///// /////
+
+// The methods below require mx and emit events like sway.
+
+func (s *Server) addWorkspace(t *tree, o *output, name string) *workspace {
+	ws := &workspace{id: t.id(), name: name, output: o}
+	o.workspaces = append(o.workspaces, ws)
+	s.emit("workspace", s.workspaceEvent("init", ws, nil))
+
+	if o.current == nil {
+		o.current = ws
+	}
+	if t.focused == nil {
+		t.focused = ws
+		s.emit("workspace", s.workspaceEvent("focus", ws, nil))
+	}
+
+	return ws
+}
+
+func (s *Server) focusWorkspace(t *tree, name string) error {
+	ws := t.workspace(name)
+	if ws == nil {
+		o := t.focusedOutput()
+		if o == nil {
+			return fmt.Errorf("no outputs")
+		}
+		ws = s.addWorkspace(t, o, name)
+	}
+	if ws == t.focused {
+		return nil
+	}
+
+	old := t.focused
+	t.focused = ws
+	ws.output.current = ws
+	s.emit("workspace", s.workspaceEvent("focus", ws, old))
+
+	// sway removes empty workspaces once they lose focus
+	if old != nil && len(old.windows) == 0 && old.output.current != old {
+		s.removeWorkspace(t, old)
+	}
+
+	t.focusedWin = 0
+	if len(ws.focus) > 0 {
+		win, _ := t.window(ws.focus[0])
+		t.focusedWin = win.ID
+		s.emit("window", s.windowEvent(t, "focus", win))
+	}
+
+	return nil
+}
+
+func (s *Server) removeWorkspace(t *tree, ws *workspace) {
+	o := ws.output
+	o.workspaces = slices.DeleteFunc(o.workspaces, func(w *workspace) bool {
+		return w == ws
+	})
+	s.emit("workspace", s.workspaceEvent("empty", ws, nil))
+}
+
+func (s *Server) focusWindow(t *tree, win *Window) {
+	_, ws := t.window(win.ID)
+	if ws != t.focused {
+		// focus the workspace without focusing its last window
+		old := t.focused
+		t.focused = ws
+		ws.output.current = ws
+		s.emit("workspace", s.workspaceEvent("focus", ws, old))
+		if old != nil && len(old.windows) == 0 && old.output.current != old {
+			s.removeWorkspace(t, old)
+		}
+	}
+
+	ws.focus = slices.DeleteFunc(ws.focus, func(id int) bool {
+		return id == win.ID
+	})
+	ws.focus = slices.Insert(ws.focus, 0, win.ID)
+	t.focusedWin = win.ID
+	s.emit("window", s.windowEvent(t, "focus", win))
+}
+
+func (s *Server) closeWindow(t *tree, id int) error {
+	win, ws := t.window(id)
+	if win == nil {
+		return fmt.Errorf("window %d not found", id)
+	}
+	ws.remove(id)
+	ws.layout()
+	s.emit("window", s.windowEvent(t, "close", win))
+
+	// focus the next window
+	if t.focusedWin == id {
+		t.focusedWin = 0
+		if len(ws.focus) > 0 && ws == t.focused {
+			next, _ := t.window(ws.focus[0])
+			s.focusWindow(t, next)
+		}
+	}
+
+	return nil
+}
+
+// moveWindow moves the window to the workspace, creating it on the focused
+// output if needed.
+func (s *Server) moveWindow(t *tree, win *Window, name string) {
+	_, from := t.window(win.ID)
+	to := t.workspace(name)
+	if to == nil {
+		to = s.addWorkspace(t, t.focusedOutput(), name)
+	}
+	if from == to {
+		return
+	}
+
+	from.remove(win.ID)
+	from.layout()
+	to.windows = append(to.windows, win)
+	to.focus = append(to.focus, win.ID)
+	to.layout()
+	s.emit("window", s.windowEvent(t, "move", win))
+
+	// the focus stays on the source workspace
+	if t.focusedWin == win.ID {
+		t.focusedWin = 0
+		if len(from.focus) > 0 {
+			next, _ := t.window(from.focus[0])
+			s.focusWindow(t, next)
+		}
+	}
+}
+
+// moveWorkspace moves the focused workspace to the output.
+func (s *Server) moveWorkspace(t *tree, outputName string) error {
+	to := t.output(outputName)
+	if to == nil {
+		return fmt.Errorf("output %s not found", outputName)
+	}
+	ws := t.focused
+	if ws == nil || ws.output == to {
+		return nil
+	}
+
+	from := ws.output
+	from.workspaces = slices.DeleteFunc(from.workspaces,
+		func(w *workspace) bool { return w == ws })
+	from.current = nil
+	if len(from.workspaces) > 0 {
+		from.current = from.workspaces[0]
+	}
+	ws.output = to
+	to.workspaces = append(to.workspaces, ws)
+	to.current = ws
+	ws.layout()
+	s.emit("workspace", s.workspaceEvent("move", ws, nil))
+
+	return nil
+}
+
+// ///// ///// /////
+// ///// TREE
+// ///// ///// /////
+
+func (t *tree) output(name string) *output {
+	for _, o := range t.outputs {
+		if o.name == name {
+			return o
+		}
+	}
+
+	return nil
+}
+
+func (t *tree) focusedOutput() *output {
+	if t.focused != nil {
+		return t.focused.output
+	}
+	if len(t.outputs) > 0 {
+		return t.outputs[0]
+	}
+
+	return nil
+}
+
+func (t *tree) allOutputs() []*output {
+	return append([]*output{t.scratch}, t.outputs...)
+}
+
+func (t *tree) workspace(name string) *workspace {
+	for _, o := range t.allOutputs() {
+		for _, ws := range o.workspaces {
+			if ws.name == name {
+				return ws
+			}
+		}
+	}
+
+	return nil
+}
+
+// window returns the window and its workspace.
+func (t *tree) window(id int) (*Window, *workspace) {
+	for _, o := range t.allOutputs() {
+		for _, ws := range o.workspaces {
+			for _, win := range ws.windows {
+				if win.ID == id {
+					return win, ws
+				}
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+func (ws *workspace) remove(id int) {
+	ws.windows = slices.DeleteFunc(ws.windows, func(w *Window) bool {
+		return w.ID == id
+	})
+	ws.focus = slices.DeleteFunc(ws.focus, func(i int) bool {
+		return i == id
+	})
+}
+
+// layout tiles the windows horizontally, with equal widths.
+func (ws *workspace) layout() {
+	var tiled []*Window
+	for _, win := range ws.windows {
+		if !win.Floating {
+			tiled = append(tiled, win)
+		}
+	}
+	if len(tiled) == 0 {
+		return
+	}
+
+	rect := ws.output.rect
+	width := rect.Width / len(tiled)
+	for i, win := range tiled {
+		win.Rect = ipc.Rect{
+			X:      rect.X + i*width,
+			Y:      rect.Y,
+			Width:  width,
+			Height: rect.Height,
+		}
+	}
+}
+
+// num returns the workspace number, or -1.
+func (ws *workspace) num() int {
+	end := 0
+	for end < len(ws.name) && ws.name[end] >= '0' && ws.name[end] <= '9' {
+		end++
+	}
+	num, err := strconv.Atoi(ws.name[:end])
+	if err != nil {
+		return -1
+	}
+
+	return num
+}
+
+// ///// ///// /////
+// ///// JSON
+// ///// ///// /////
+
+func (t *tree) rootNode() map[string]any {
+	var outputs []any
+	for _, o := range t.allOutputs() {
+		outputs = append(outputs, t.outputNode(o))
+	}
+
+	return map[string]any{
+		"id":             1,
+		"name":           "root",
+		"type":           "root",
+		"layout":         "splith",
+		"focused":        false,
+		"nodes":          outputs,
+		"floating_nodes": []any{},
+	}
+}
+
+func (t *tree) outputNode(o *output) map[string]any {
+	var spaces []any
+	for _, ws := range o.workspaces {
+		spaces = append(spaces, t.workspaceNode(ws))
+	}
+	current := ""
+	if o.current != nil {
+		current = o.current.name
+	}
+
+	return map[string]any{
+		"id":                o.id,
+		"name":              o.name,
+		"type":              "output",
+		"layout":            "output",
+		"rect":              o.rect,
+		"active":            o != t.scratch,
+		"current_workspace": current,
+		"focused":           false,
+		"nodes":             spaces,
+		"floating_nodes":    []any{},
+	}
+}
+
+func (t *tree) workspaceNode(ws *workspace) map[string]any {
+	tiled := []any{}
+	floating := []any{}
+	for _, win := range ws.windows {
+		if win.Floating {
+			floating = append(floating, t.windowNode(win))
+		} else {
+			tiled = append(tiled, t.windowNode(win))
+		}
+	}
+
+	return map[string]any{
+		"id":             ws.id,
+		"name":           ws.name,
+		"num":            ws.num(),
+		"type":           "workspace",
+		"layout":         "splith",
+		"output":         ws.output.name,
+		"rect":           ws.output.rect,
+		"focused":        ws == t.focused && t.focusedWin == 0,
+		"visible":        ws.output.current == ws,
+		"focus":          ws.focus,
+		"nodes":          tiled,
+		"floating_nodes": floating,
+	}
+}
+
+func (t *tree) windowNode(win *Window) map[string]any {
+	typ := "con"
+	if win.Floating {
+		typ = "floating_con"
+	}
+	fullscreen := 0
+	if win.Fullscreen {
+		fullscreen = 1
+	}
+	_, ws := t.window(win.ID)
+
+	return map[string]any{
+		"id":              win.ID,
+		"name":            win.Title,
+		"type":            typ,
+		"layout":          "none",
+		"rect":            win.Rect,
+		"border":          win.Border,
+		"focused":         win.ID == t.focusedWin,
+		"visible":         ws != nil && ws.output.current == ws,
+		"urgent":          win.Urgent,
+		"fullscreen_mode": fullscreen,
+		"app_id":          win.AppID,
+		"pid":             win.PID,
+		"focus":           []int{},
+		"marks":           []string{},
+		"nodes":           []any{},
+		"floating_nodes":  []any{},
+		"window_properties": map[string]any{
+			"class": "",
+			"title": win.Title,
+		},
+	}
+}
+
+func (t *tree) workspaceList() []any {
+	ret := []any{}
+	for _, o := range t.outputs {
+		for _, ws := range o.workspaces {
+			node := t.workspaceNode(ws)
+			node["focused"] = ws == t.focused
+			delete(node, "nodes")
+			delete(node, "floating_nodes")
+			ret = append(ret, node)
+		}
+	}
+
+	return ret
+}
+
+func (t *tree) outputList() []any {
+	ret := []any{}
+	for _, o := range t.outputs {
+		node := t.outputNode(o)
+		node["focused"] = t.focused != nil && t.focused.output == o
+		delete(node, "nodes")
+		delete(node, "floating_nodes")
+		ret = append(ret, node)
+	}
+
+	return ret
+}
+
+func (s *Server) windowEvent(t *tree, change string, win *Window) any {
+	return map[string]any{
+		"change":    change,
+		"container": t.windowNode(win),
+	}
+}
+
+func (s *Server) workspaceEvent(change string, current, old *workspace) any {
+	node := func(ws *workspace) any {
+		if ws == nil {
+			return nil
+		}
+		return map[string]any{
+			"id":     ws.id,
+			"name":   ws.name,
+			"num":    ws.num(),
+			"type":   "workspace",
+			"output": ws.output.name,
+		}
+	}
+
+	return map[string]any{
+		"change":  change,
+		"current": node(current),
+		"old":     node(old),
+	}
+}
@@ -3,10 +3,20 @@ package types
 import "github.com/pancsta/gosway/ipc"
 
 type WindowData struct {
-	ID        int
-	Output    string
-	Workspace string
-	Title     string
-	App       string
-	Rect      ipc.Rect
+	ID         int      `json:"id"`
+	Output     string   `json:"output"`
+	Workspace  string   `json:"workspace"`
+	Title      string   `json:"title"`
+	App        string   `json:"app"`
+	Rect       ipc.Rect `json:"rect"`
+	Floating   bool     `json:"floating"`
+	Fullscreen bool     `json:"fullscreen"`
+	Urgent     bool     `json:"urgent"`
+}
+
+type SpaceData struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Output  string `json:"output"`
+	Visible bool   `json:"visible"`
 }
@@ -0,0 +1,112 @@
+package watcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// WatchFile calls fn after the file changes, at most once per debounce,
+// until ctx is done. The parent dir is watched, as editors usually replace
+// files on save. A missing parent dir gets watched via its nearest existing
+// ancestor, until created.
+func WatchFile(
+	ctx context.Context, path string, debounce time.Duration, fn func(),
+) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	path = filepath.Clean(path)
+	dir := filepath.Dir(path)
+	watched := existingAncestor(dir)
+	err = w.Add(watched)
+	if err != nil {
+		w.Close()
+		return err
+	}
+
+	go func() {
+		defer w.Close()
+		var timer *time.Timer
+		changed := func() {
+			// collapse bursts of writes into a single call
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.AfterFunc(debounce, fn)
+		}
+
+		for {
+			select {
+
+			case event, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				name := filepath.Clean(event.Name)
+
+				// a dir on the way to the file got created or removed
+				if name != path && isAncestor(name, dir) {
+					next := existingAncestor(dir)
+					if next == watched {
+						continue
+					}
+					_ = w.Remove(watched)
+					if err := w.Add(next); err != nil {
+						continue
+					}
+					watched = next
+					// created before the watch
+					if _, err := os.Stat(path); err == nil && next == dir {
+						changed()
+					}
+					continue
+				}
+
+				if name != path || event.Op == fsnotify.Chmod {
+					continue
+				}
+				changed()
+
+			case _, ok := <-w.Errors:
+				// keep watching, the next event will retry
+				if !ok {
+					return
+				}
+
+			case <-ctx.Done():
+				if timer != nil {
+					timer.Stop()
+				}
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// existingAncestor returns the dir, or its nearest existing parent.
+func existingAncestor(dir string) string {
+	for {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// isAncestor returns true if dir is path or one of its parents.
+func isAncestor(dir, path string) bool {
+	return dir == path ||
+		strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/")
+}
@@ -0,0 +1,91 @@
+package watcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchFile(t *testing.T) {
+	tests := []struct {
+		name string
+		// dir of the file, relative to the temp dir
+		dir string
+		// mkdir creates the dir after starting the watch
+		mkdir bool
+	}{
+		{"existing dir", "sway-yasm", false},
+		{"missing dir", "sway-yasm", true},
+		{"missing dirs", "config/sway-yasm", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			dir := filepath.Join(root, tt.dir)
+			path := filepath.Join(dir, "config.yaml")
+			if !tt.mkdir {
+				if err := os.MkdirAll(dir, 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			changed := make(chan struct{}, 1)
+			err := WatchFile(ctx, path, 10*time.Millisecond, func() {
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			// let the watch move to the new dir
+			time.Sleep(50 * time.Millisecond)
+			// a sibling doesn't count
+			err = os.WriteFile(filepath.Join(dir, "other.yaml"), nil, 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case <-changed:
+				t.Fatal("changed by a sibling")
+			case <-time.After(50 * time.Millisecond):
+			}
+
+			if err := os.WriteFile(path, []byte("{}"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case <-changed:
+			case <-time.After(2 * time.Second):
+				t.Fatal("no change")
+			}
+		})
+	}
+}
+
+func TestIsAncestor(t *testing.T) {
+	tests := []struct {
+		dir, path string
+		want      bool
+	}{
+		{"/a", "/a", true},
+		{"/a", "/a/b", true},
+		{"/", "/a", true},
+		{"/a/b", "/a", false},
+		{"/a", "/ab", false},
+	}
+	for _, tt := range tests {
+		if got := isAncestor(tt.dir, tt.path); got != tt.want {
+			t.Errorf("isAncestor(%s, %s) = %v", tt.dir, tt.path, got)
+		}
+	}
+}
@@ -24,7 +24,18 @@ type PathWatcher struct {
 	ResultsLock sync.Mutex
 	Results     []string
 	EnvPath     string
-
+	// max 1 refresh of a dir per Debounce, see SetDebounce
+	Debounce time.Duration
+	// OnRefreshed is called with the number of executables after each full
+	// refresh, should not block
+	OnRefreshed func(count int)
+
+	// mutMx serializes the mutations from outside of the handlers, as the
+	// machine's queue isn't safe for concurrent use
+	mutMx sync.Mutex
+	// refreshed is shared by WhenRefreshed and guarded by refreshedMx
+	refreshed   <-chan struct{}
+	refreshedMx sync.Mutex
 	watcher     *fsnotify.Watcher
 	dirCache    map[string][]string
 	dirState    map[string]*am.Machine
@@ -35,6 +46,7 @@ type PathWatcher struct {
 func New(ctx context.Context, logger *log.Logger) (*PathWatcher, error) {
 	w := &PathWatcher{
 		EnvPath:     os.Getenv("PATH"),
+		Debounce:    time.Second,
 		dirCache:    make(map[string][]string),
 		dirState:    make(map[string]*am.Machine),
 		ongoing:     make(map[string]context.Context),
@@ -140,19 +152,21 @@ func (w *PathWatcher) watchLoop(ctx context.Context) {
 
 		case event, ok := <-w.watcher.Events:
 			if !ok {
-				w.Mach.Remove1(ss.Watching, nil)
+				w.mutate(func() { w.Mach.Remove1(ss.Watching, nil) })
 				return
 			}
-			w.Mach.Add1(ss.ChangeEvent, am.A{
-				"fsnotify.Event": event,
+			w.mutate(func() {
+				w.Mach.Add1(ss.ChangeEvent, am.A{
+					"fsnotify.Event": event,
+				})
 			})
 
 		case err, ok := <-w.watcher.Errors:
 			if !ok {
-				w.Mach.Remove1(ss.Watching, nil)
+				w.mutate(func() { w.Mach.Remove1(ss.Watching, nil) })
 				return
 			}
-			w.Mach.AddErr(err)
+			w.mutate(func() { w.Mach.AddErr(err) })
 
 		case <-ctx.Done():
 			// state expired
@@ -209,8 +223,7 @@ func (w *PathWatcher) RefreshingState(e *am.Event) {
 
 	dir := e.Args["dir"].(string)
 	dirState := w.dirState[dir]
-	// TODO config
-	debounce := time.Second
+	debounce := w.Debounce
 
 	// max 1 refresh per second
 	since := time.Since(w.lastRefresh[dir])
@@ -221,9 +234,11 @@ func (w *PathWatcher) RefreshingState(e *am.Event) {
 
 		go func() {
 			time.Sleep(debounce)
-			w.Mach.Add1(ss.Refreshing, am.A{
-				"dir":        dir,
-				"isDebounce": true,
+			w.mutate(func() {
+				w.Mach.Add1(ss.Refreshing, am.A{
+					"dir":        dir,
+					"isDebounce": true,
+				})
 			})
 		}()
 
@@ -241,16 +256,18 @@ func (w *PathWatcher) RefreshingState(e *am.Event) {
 
 		// TODO returns deleted files on delete event
 		executables, err := listExecutables(dir)
-		if err != nil {
-			e.Machine.AddErr(err)
-		}
 
-		w.Mach.Remove1(ss.Refreshing, am.A{
-			"dir": dir,
-		})
-		w.Mach.Add1(ss.Refreshed, am.A{
-			"dir":         dir,
-			"executables": executables,
+		w.mutate(func() {
+			if err != nil {
+				w.Mach.AddErr(err)
+			}
+			w.Mach.Remove1(ss.Refreshing, am.A{
+				"dir": dir,
+			})
+			w.Mach.Add1(ss.Refreshed, am.A{
+				"dir":         dir,
+				"executables": executables,
+			})
 		})
 	}()
 }
@@ -316,14 +333,56 @@ func (w *PathWatcher) AllRefreshedState(e *am.Event) {
 		w.Results = append(w.Results, executables...)
 	}
 	w.Results = uniqueStrings(w.Results)
+
+	if w.OnRefreshed != nil {
+		w.OnRefreshed(len(w.Results))
+	}
 }
 
 func (w *PathWatcher) Start() {
-	w.Mach.Add1(ss.Init, nil)
+	w.mutate(func() { w.Mach.Add1(ss.Init, nil) })
 }
 
 func (w *PathWatcher) Stop() {
-	w.Mach.Remove1(ss.Init, nil)
+	w.mutate(func() { w.Mach.Remove1(ss.Init, nil) })
+}
+
+// SetDebounce changes Debounce of a started watcher.
+func (w *PathWatcher) SetDebounce(debounce time.Duration) {
+	w.mutate(func() {
+		w.Mach.Eval("SetDebounce", func() {
+			w.Debounce = debounce
+		}, nil)
+	})
+}
+
+// WhenRefreshed returns a channel closed once all the dirs got refreshed.
+// Callers share a single binding, as the machine can't resolve many bindings
+// of the same state at once.
+func (w *PathWatcher) WhenRefreshed() <-chan struct{} {
+	w.refreshedMx.Lock()
+	defer w.refreshedMx.Unlock()
+
+	if w.refreshed != nil {
+		select {
+		case <-w.refreshed:
+		default:
+			// still pending
+			return w.refreshed
+		}
+	}
+	w.refreshed = w.Mach.When1(ss.AllRefreshed, nil)
+
+	return w.refreshed
+}
+
+// mutate runs fn, which mutates the machine from outside of its handlers,
+// eg from a goroutine. Handlers mutate the machine directly.
+func (w *PathWatcher) mutate(fn func()) {
+	w.mutMx.Lock()
+	defer w.mutMx.Unlock()
+
+	fn()
 }
 
 // ///// ///// /////